@@ -0,0 +1,42 @@
+// components/body_limit.go
+
+package components
+
+import (
+	"errors"
+	"net/http"
+)
+
+// BodyLimitMiddleware caps request bodies and query strings at maxBytes.
+// Requests that declare a larger body or carry a larger query are refused
+// with 413 up front; bodies without a length are cut off by
+// http.MaxBytesReader, which handlers detect with IsBodyTooLarge. The bodies
+// of the upload paths, such as archive extraction, are left to their
+// handlers to bound; their query strings are still capped.
+func BodyLimitMiddleware(maxBytes int64, uploads ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upload := false
+			for _, path := range uploads {
+				if r.URL.Path == path {
+					upload = true
+				}
+			}
+			if (!upload && r.ContentLength > maxBytes) || int64(len(r.URL.RawQuery)) > maxBytes {
+				LogRequest(r, "Refused request of %d body bytes and %d query bytes", r.ContentLength, len(r.URL.RawQuery))
+				http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && !upload {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsBodyTooLarge reports whether err came from reading past the body limit
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
@@ -0,0 +1,141 @@
+// components/compress.go
+
+package components
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// Content types that are already compressed or are streamed incrementally
+// and must be passed through untouched
+var uncompressedTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-xz",
+	"application/x-bzip2",
+	"application/octet-stream",
+	"text/event-stream",
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize  int
+	status   int
+	buf      []byte
+	decided  bool
+	compress bool
+	gz       *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+// Chooses between gzip and passthrough once enough of the body is buffered,
+// then writes the headers and whatever was held back
+func (g *gzipResponseWriter) decide(compress bool) {
+	g.decided = true
+	header := g.Header()
+	if header.Get("Content-Encoding") != "" || g.status == http.StatusNoContent || g.status == http.StatusNotModified {
+		compress = false
+	}
+	// Byte ranges refer to the uncompressed body, so ranged and range-capable
+	// responses are sent as they are
+	if g.status == http.StatusPartialContent || header.Get("Content-Range") != "" || header.Get("Accept-Ranges") != "" {
+		compress = false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" && len(g.buf) > 0 {
+		contentType = http.DetectContentType(g.buf)
+		header.Set("Content-Type", contentType)
+	}
+	for _, prefix := range uncompressedTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			compress = false
+			break
+		}
+	}
+
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		g.compress = true
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	if len(g.buf) > 0 {
+		g.writeBody(g.buf)
+		g.buf = nil
+	}
+}
+
+func (g *gzipResponseWriter) writeBody(p []byte) (int, error) {
+	if g.compress {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.decided {
+		return g.writeBody(p)
+	}
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= g.minSize {
+		g.decide(true)
+	}
+	return len(p), nil
+}
+
+// Flush commits to the current decision so streaming handlers keep working;
+// a flush before the threshold is reached disables compression
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.compress {
+		g.gz.Flush()
+	}
+	if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) close() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.compress {
+		g.gz.Close()
+	}
+}
+
+// GzipMiddleware compresses responses of at least minSize bytes for clients
+// sending Accept-Encoding: gzip
+func GzipMiddleware(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// components/compress_test.go
+
+package components
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat("napi serves text that compresses well\n", 100)
+	tests := []struct {
+		name     string
+		rangeHdr string
+		handler  http.HandlerFunc
+		wantGzip bool
+		wantBody string
+	}{
+		{"plain text", "", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, body)
+		}, true, body},
+		{"small body", "", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "short")
+		}, false, "short"},
+		{"already compressed type", "", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/gzip")
+			io.WriteString(w, body)
+		}, false, body},
+		{"range capable file", "", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "notes.txt", time.Time{}, strings.NewReader(body))
+		}, false, body},
+		{"range request", "bytes=100-1099", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "notes.txt", time.Time{}, strings.NewReader(body))
+		}, false, body[100:1100]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/file", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			if tt.rangeHdr != "" {
+				req.Header.Set("Range", tt.rangeHdr)
+			}
+			w := httptest.NewRecorder()
+			GzipMiddleware(256)(tt.handler).ServeHTTP(w, req)
+
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", w.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+			got := w.Body.Bytes()
+			if gzipped {
+				gz, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err = io.ReadAll(gz); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.rangeHdr != "" && w.Code != http.StatusPartialContent {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+			}
+			if string(got) != tt.wantBody {
+				t.Errorf("body has %d bytes, want %d", len(got), len(tt.wantBody))
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// components/config.go
+
+package components
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config reads settings from environment variables. Invalid values are
+// collected rather than fatal, so every problem can be reported at once, and
+// the effective value of each setting is kept for the startup summary.
+type Config struct {
+	problems []string
+	entries  []configEntry
+}
+
+type configEntry struct {
+	name      string
+	value     string
+	isDefault bool
+}
+
+func (c *Config) record(name, value string, isDefault bool) {
+	c.entries = append(c.entries, configEntry{name: name, value: value, isDefault: isDefault})
+}
+
+// Problemf records a problem that is not tied to a single value, such as two
+// settings that conflict
+func (c *Config) Problemf(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+func (c *Config) invalid(name, value, expected string) {
+	c.Problemf("%s=%q: %s", name, value, expected)
+}
+
+// Problems returns the problems found so far, in the order settings were read
+func (c *Config) Problems() []string {
+	return c.problems
+}
+
+// String reads a free-form setting
+func (c *Config) String(name, fallback string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		c.record(name, fallback, true)
+		return fallback
+	}
+	c.record(name, value, false)
+	return value
+}
+
+// Secret reads a setting whose value is never shown in the summary
+func (c *Config) Secret(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		c.record(name, "", true)
+		return ""
+	}
+	c.record(name, "<redacted>", false)
+	return value
+}
+
+// Choice reads a setting that must be one of choices, compared case
+// insensitively and returned in lower case
+func (c *Config) Choice(name, fallback string, choices ...string) string {
+	value := strings.ToLower(os.Getenv(name))
+	if value == "" {
+		c.record(name, fallback, true)
+		return fallback
+	}
+	for _, choice := range choices {
+		if value == choice {
+			c.record(name, value, false)
+			return value
+		}
+	}
+	c.invalid(name, os.Getenv(name), "expected "+strings.Join(choices, ", "))
+	return fallback
+}
+
+// Bool reads a setting parsed by strconv.ParseBool
+func (c *Config) Bool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		c.record(name, strconv.FormatBool(fallback), true)
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		c.invalid(name, value, "expected true or false")
+		return fallback
+	}
+	c.record(name, strconv.FormatBool(parsed), false)
+	return parsed
+}
+
+// Int64 reads a whole number of at least min
+func (c *Config) Int64(name string, fallback, min int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		c.record(name, strconv.FormatInt(fallback, 10), true)
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < min {
+		c.invalid(name, value, "expected a whole number of at least "+strconv.FormatInt(min, 10))
+		return fallback
+	}
+	c.record(name, strconv.FormatInt(parsed, 10), false)
+	return parsed
+}
+
+// Int reads a whole number of at least min
+func (c *Config) Int(name string, fallback, min int) int {
+	return int(c.Int64(name, int64(fallback), int64(min)))
+}
+
+// Duration reads a duration such as 30s or 15m. Zero is only accepted with
+// allowZero, which settings use to mean "off".
+func (c *Config) Duration(name string, fallback time.Duration, allowZero bool) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		c.record(name, fallback.String(), true)
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 || (parsed == 0 && !allowZero) {
+		expected := "expected a positive duration such as 30s or 15m"
+		if allowZero {
+			expected = "expected a duration such as 30s or 15m, or 0"
+		}
+		c.invalid(name, value, expected)
+		return fallback
+	}
+	c.record(name, parsed.String(), false)
+	return parsed
+}
+
+// Port reads a TCP port number; an empty fallback leaves the port unset
+func (c *Config) Port(name, fallback string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		c.record(name, fallback, true)
+		return fallback
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		c.invalid(name, value, "expected a port number between 1 and 65535")
+		return fallback
+	}
+	c.record(name, value, false)
+	return value
+}
+
+// Origins reads a comma-separated list of origins such as
+// https://app.example.com, returned in lower case. "*" is refused because
+// the origins are allowed to send credentials.
+func (c *Config) Origins(name string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		c.record(name, "", true)
+		return nil
+	}
+	var origins []string
+	for _, entry := range strings.Split(value, ",") {
+		origin := strings.ToLower(strings.TrimSpace(entry))
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			parsed.User != nil || parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			c.invalid(name, entry, "expected origins such as https://app.example.com")
+			continue
+		}
+		origins = append(origins, origin)
+	}
+	c.record(name, strings.Join(origins, ","), false)
+	return origins
+}
+
+// Summary returns one line per setting read with its effective value, marking
+// those left at their default
+func (c *Config) Summary() []string {
+	width := 0
+	for _, entry := range c.entries {
+		if len(entry.name) > width {
+			width = len(entry.name)
+		}
+	}
+	lines := make([]string, 0, len(c.entries))
+	for _, entry := range c.entries {
+		value := entry.value
+		if value == "" {
+			value = "(unset)"
+		}
+		if entry.isDefault && entry.value != "" {
+			value += " (default)"
+		}
+		lines = append(lines, fmt.Sprintf("%-*s  %s", width, entry.name, value))
+	}
+	return lines
+}
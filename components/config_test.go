@@ -0,0 +1,85 @@
+// components/config_test.go
+
+package components
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigBool(t *testing.T) {
+	tests := []struct {
+		value       string
+		want        bool
+		wantProblem bool
+	}{
+		{"", true, false},
+		{"true", true, false},
+		{"false", false, false},
+		{"0", false, false},
+		{"FALSE", false, false},
+		{"off", true, true},
+		{"yes", true, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("COOKIE_SECURE", tt.value)
+		c := &Config{}
+		if got := c.Bool("COOKIE_SECURE", true); got != tt.want {
+			t.Errorf("COOKIE_SECURE=%q: Bool() = %v, want %v", tt.value, got, tt.want)
+		}
+		if got := len(c.Problems()) > 0; got != tt.wantProblem {
+			t.Errorf("COOKIE_SECURE=%q: problems = %q", tt.value, c.Problems())
+		}
+	}
+}
+
+func TestConfigChoice(t *testing.T) {
+	tests := []struct {
+		value       string
+		want        string
+		wantProblem bool
+	}{
+		{"", "strict", false},
+		{"lax", "lax", false},
+		{"None", "none", false},
+		{"STRICT", "strict", false},
+		{"relaxed", "strict", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("COOKIE_SAMESITE", tt.value)
+		c := &Config{}
+		if got := c.Choice("COOKIE_SAMESITE", "strict", "strict", "lax", "none"); got != tt.want {
+			t.Errorf("COOKIE_SAMESITE=%q: Choice() = %q, want %q", tt.value, got, tt.want)
+		}
+		if got := len(c.Problems()) > 0; got != tt.wantProblem {
+			t.Errorf("COOKIE_SAMESITE=%q: problems = %q", tt.value, c.Problems())
+		}
+	}
+}
+
+func TestConfigOrigins(t *testing.T) {
+	tests := []struct {
+		value        string
+		want         string
+		wantProblems int
+	}{
+		{"", "", 0},
+		{"https://app.example.com", "https://app.example.com", 0},
+		{"https://App.Example.com, http://localhost:3000", "https://app.example.com,http://localhost:3000", 0},
+		{"*", "", 1},
+		{"https://app.example.com/path", "", 1},
+		{"app.example.com", "", 1},
+		{"ftp://files.example.com,https://ok.example.com", "https://ok.example.com", 1},
+		{"https://user@app.example.com", "", 1},
+	}
+	for _, tt := range tests {
+		t.Setenv("ALLOWED_ORIGINS", tt.value)
+		c := &Config{}
+		if got := strings.Join(c.Origins("ALLOWED_ORIGINS"), ","); got != tt.want {
+			t.Errorf("ALLOWED_ORIGINS=%q: Origins() = %q, want %q", tt.value, got, tt.want)
+		}
+		if got := len(c.Problems()); got != tt.wantProblems {
+			t.Errorf("ALLOWED_ORIGINS=%q: problems = %q", tt.value, c.Problems())
+		}
+	}
+}
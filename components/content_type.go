@@ -0,0 +1,24 @@
+// components/content_type.go
+
+package components
+
+import (
+	"mime"
+	"net/http"
+)
+
+// RequireJSONMiddleware refuses requests whose Content-Type is not
+// application/json with 415, so a client sending a form or no type at all
+// learns what is expected instead of getting a decoding error. Parameters
+// such as charset are accepted.
+func RequireJSONMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			LogRequest(r, "Refused request with Content-Type %q", r.Header.Get("Content-Type"))
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
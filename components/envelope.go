@@ -0,0 +1,141 @@
+// components/envelope.go
+
+package components
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// EnvelopeHeader selects the response format per request: "1" wraps
+// responses in the envelope, "0" keeps the legacy shapes. Without it the
+// server default applies. Enveloped responses carry it back set to "1".
+const EnvelopeHeader = "X-Response-Envelope"
+
+// Holds back JSON and plain-text error bodies so they can be rewrapped once
+// the handler is done; everything else is passed through as written
+type envelopeWriter struct {
+	http.ResponseWriter
+	status  int
+	decided bool
+	wrap    bool
+	buf     bytes.Buffer
+}
+
+func (e *envelopeWriter) WriteHeader(status int) {
+	if e.decided {
+		return
+	}
+	e.decided = true
+	e.status = status
+	contentType := e.Header().Get("Content-Type")
+	isJSON := strings.HasPrefix(contentType, "application/json")
+	switch {
+	case status >= 200 && status < 300 && status != http.StatusNoContent:
+		e.wrap = isJSON
+	case status >= 400:
+		e.wrap = isJSON || strings.HasPrefix(contentType, "text/plain")
+	}
+	if !e.wrap {
+		e.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (e *envelopeWriter) Write(p []byte) (int, error) {
+	if !e.decided {
+		if e.Header().Get("Content-Type") == "" {
+			e.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.wrap {
+		return e.buf.Write(p)
+	}
+	return e.ResponseWriter.Write(p)
+}
+
+func (e *envelopeWriter) Flush() {
+	if e.wrap {
+		return
+	}
+	if flusher, ok := e.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Writes the held back body in the envelope: {"ok": true, "data": ...} for
+// successes, the error object with "ok": false added for failures. Bodies
+// that are not valid JSON where JSON was declared are sent unchanged.
+func (e *envelopeWriter) finish(r *http.Request) {
+	if !e.wrap {
+		return
+	}
+	var body []byte
+	var err error
+	if e.status < 400 {
+		body, err = json.Marshal(map[string]interface{}{
+			"ok":   true,
+			"data": json.RawMessage(e.buf.Bytes()),
+		})
+	} else if strings.HasPrefix(e.Header().Get("Content-Type"), "application/json") {
+		fields := map[string]interface{}{}
+		if err = json.Unmarshal(e.buf.Bytes(), &fields); err == nil {
+			fields["ok"] = false
+			body, err = json.Marshal(fields)
+		}
+	} else {
+		fields := map[string]interface{}{
+			"ok":    false,
+			"error": strings.TrimSpace(e.buf.String()),
+		}
+		if id := RequestID(r); id != "" {
+			fields["request_id"] = id
+		}
+		body, err = json.Marshal(fields)
+	}
+	if err != nil {
+		e.ResponseWriter.WriteHeader(e.status)
+		e.ResponseWriter.Write(e.buf.Bytes())
+		return
+	}
+
+	header := e.Header()
+	header.Set("Content-Type", "application/json")
+	header.Set(EnvelopeHeader, "1")
+	header.Del("Content-Length")
+	e.ResponseWriter.WriteHeader(e.status)
+	e.ResponseWriter.Write(append(body, '\n'))
+}
+
+// EnvelopeMiddleware gives JSON responses one shape, {"ok": true, "data": ...}
+// on success and {"ok": false, "error": ...} on failure, with plain-text
+// errors converted to JSON. It applies when enabled by default or requested
+// through EnvelopeHeader. Streams, files and the paths in skip, such as the
+// OpenAPI document, are left alone.
+func EnvelopeMiddleware(enabledByDefault bool, skip ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			enabled := enabledByDefault
+			switch r.Header.Get(EnvelopeHeader) {
+			case "1", "true":
+				enabled = true
+			case "0", "false":
+				enabled = false
+			}
+			for _, path := range skip {
+				if r.URL.Path == path {
+					enabled = false
+				}
+			}
+			if !enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ew := &envelopeWriter{ResponseWriter: w}
+			next.ServeHTTP(ew, r)
+			ew.finish(r)
+		})
+	}
+}
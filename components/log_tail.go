@@ -0,0 +1,151 @@
+// components/log_tail.go
+
+package components
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How often the followed file is checked for new data and rotation
+const logTailInterval = 500 * time.Millisecond
+
+// Opens path positioned at its end
+func openAtEnd(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Reports whether the file at path is no longer the one that is open, either
+// because it was replaced (rotation) or truncated below the read position
+func rotated(path string, file *os.File) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	opened, err := file.Stat()
+	if err != nil {
+		return true
+	}
+	if !os.SameFile(current, opened) {
+		return true
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	return err == nil && current.Size() < offset
+}
+
+// FollowFile calls send with each line appended to file, which must be open
+// at path, until ctx is done or send fails. Rotated or truncated files are
+// reopened, reading the new file from the start. A trailing partial line is
+// held back until the rest of it is written. FollowFile takes ownership of
+// file and closes it when it returns.
+func FollowFile(ctx context.Context, path string, file *os.File, send func(line string) error) {
+	defer func() { file.Close() }()
+
+	reader := bufio.NewReader(file)
+	var partial strings.Builder
+	// Sends every complete line available
+	drain := func() bool {
+		for {
+			chunk, err := reader.ReadString('\n')
+			partial.WriteString(chunk)
+			if err != nil {
+				return true
+			}
+			line := strings.TrimRight(partial.String(), "\r\n")
+			partial.Reset()
+			if err := send(line); err != nil {
+				return false
+			}
+		}
+	}
+
+	ticker := time.NewTicker(logTailInterval)
+	defer ticker.Stop()
+	for {
+		if !drain() {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if rotated(path, file) {
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			// Finish what was written to the old file before switching
+			if !drain() {
+				next.Close()
+				return
+			}
+			file.Close()
+			file = next
+			reader.Reset(file)
+			partial.Reset()
+		}
+	}
+}
+
+// TailLogHandler streams lines appended to the file at path to a WebSocket
+// client, starting at the current end of the file. Rotated or truncated files
+// are reopened, reading the new file from the start.
+func TailLogHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		file, err := openAtEnd(path)
+		if err != nil {
+			log.Printf("Failed to open %s for tailing: %v", path, err)
+			http.Error(w, "Error opening log file", http.StatusInternalServerError)
+			return
+		}
+
+		session, err := upgradeWebSocket(w, r)
+		if err != nil {
+			file.Close()
+			log.Printf("Failed to upgrade websocket: %v", err)
+			return
+		}
+		defer session.Close()
+		conn := session.conn
+		log.Printf("User %s started following %s from %s", WebSocketUser(r), path, r.RemoteAddr)
+
+		// The client only ever closes; reading is needed to notice that
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		FollowFile(ctx, path, file, func(line string) error {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+				return err
+			}
+			session.Touch()
+			return nil
+		})
+	}
+}
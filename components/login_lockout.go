@@ -0,0 +1,94 @@
+// components/login_lockout.go
+
+package components
+
+import (
+	"sync"
+	"time"
+)
+
+// Expired entries are swept once the tracker holds this many usernames
+const lockoutSweepSize = 1024
+
+type lockoutEntry struct {
+	failures    int
+	firstFailed time.Time
+	lockedUntil time.Time
+}
+
+// LoginLockout counts consecutive failed logins per username, whatever the
+// client address, and locks the username for a cooldown once MaxFailures
+// failures fall within Window. State is kept in memory and lost on restart.
+type LoginLockout struct {
+	MaxFailures int
+	Window      time.Duration
+	Cooldown    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+}
+
+// NewLoginLockout returns a tracker; maxFailures of 0 disables locking
+func NewLoginLockout(maxFailures int, window, cooldown time.Duration) *LoginLockout {
+	return &LoginLockout{
+		MaxFailures: maxFailures,
+		Window:      window,
+		Cooldown:    cooldown,
+		entries:     map[string]*lockoutEntry{},
+	}
+}
+
+// Locked reports whether username is locked and for how much longer
+func (l *LoginLockout) Locked(username string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[username]
+	if !ok {
+		return 0, false
+	}
+	remaining := time.Until(entry.lockedUntil)
+	return remaining, remaining > 0
+}
+
+// Failure records a failed login and reports whether it locked username
+func (l *LoginLockout) Failure(username string) bool {
+	if l.MaxFailures <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.entries) >= lockoutSweepSize {
+		l.sweep(now)
+	}
+	entry, ok := l.entries[username]
+	if !ok || (now.Sub(entry.firstFailed) > l.Window && now.After(entry.lockedUntil)) {
+		entry = &lockoutEntry{firstFailed: now}
+		l.entries[username] = entry
+	}
+	entry.failures++
+	if entry.failures >= l.MaxFailures {
+		entry.failures = 0
+		entry.firstFailed = now
+		entry.lockedUntil = now.Add(l.Cooldown)
+		return true
+	}
+	return false
+}
+
+// Success clears the failures recorded for username
+func (l *LoginLockout) Success(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, username)
+}
+
+// Drops entries whose window and cooldown have both passed
+func (l *LoginLockout) sweep(now time.Time) {
+	for username, entry := range l.entries {
+		if now.Sub(entry.firstFailed) > l.Window && now.After(entry.lockedUntil) {
+			delete(l.entries, username)
+		}
+	}
+}
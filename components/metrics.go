@@ -0,0 +1,200 @@
+// components/metrics.go
+
+package components
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Upper bounds in seconds of the request duration histogram buckets
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestLabels struct {
+	method string
+	route  string
+	status int
+}
+
+type durationHistogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+var (
+	metricsMu        sync.Mutex
+	requestCounts    = map[requestLabels]uint64{}
+	requestDurations = map[requestLabels]*durationHistogram{}
+	rateLimitCounts  = map[string]uint64{}
+	activeWebSockets int64
+)
+
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (mw *metricsWriter) WriteHeader(status int) {
+	if mw.status == 0 {
+		mw.status = status
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsWriter) Write(p []byte) (int, error) {
+	if mw.status == 0 {
+		mw.status = http.StatusOK
+	}
+	return mw.ResponseWriter.Write(p)
+}
+
+func (mw *metricsWriter) Flush() {
+	if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+		if mw.status == 0 {
+			mw.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Labels requests with the route template rather than the raw path so that
+// path variables cannot blow up the number of series
+func routeLabel(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+// MetricsMiddleware records request counts and durations by method, route and
+// status, counting 429 responses as rate-limit rejections
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		mw := &metricsWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r)
+		elapsed := time.Since(start).Seconds()
+
+		status := mw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := routeLabel(r)
+		// Durations are kept per route only, status would multiply the buckets
+		histogramKey := requestLabels{method: r.Method, route: route}
+
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		requestCounts[requestLabels{method: r.Method, route: route, status: status}]++
+		histogram := requestDurations[histogramKey]
+		if histogram == nil {
+			histogram = &durationHistogram{counts: make([]uint64, len(durationBuckets))}
+			requestDurations[histogramKey] = histogram
+		}
+		for i, bound := range durationBuckets {
+			if elapsed <= bound {
+				histogram.counts[i]++
+				break
+			}
+		}
+		histogram.sum += elapsed
+		histogram.count++
+		if status == http.StatusTooManyRequests {
+			rateLimitCounts[route]++
+		}
+	})
+}
+
+// Escapes a label value for the Prometheus text format
+func labelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// MetricsHandler exposes the collected metrics in the Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var out strings.Builder
+
+	metricsMu.Lock()
+	counterKeys := make([]requestLabels, 0, len(requestCounts))
+	for key := range requestCounts {
+		counterKeys = append(counterKeys, key)
+	}
+	sort.Slice(counterKeys, func(i, j int) bool {
+		a, b := counterKeys[i], counterKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	out.WriteString("# HELP napi_http_requests_total Total HTTP requests by method, route and status.\n")
+	out.WriteString("# TYPE napi_http_requests_total counter\n")
+	for _, key := range counterKeys {
+		fmt.Fprintf(&out, "napi_http_requests_total{method=\"%s\",route=\"%s\",status=\"%d\"} %d\n",
+			labelValue(key.method), labelValue(key.route), key.status, requestCounts[key])
+	}
+
+	histogramKeys := make([]requestLabels, 0, len(requestDurations))
+	for key := range requestDurations {
+		histogramKeys = append(histogramKeys, key)
+	}
+	sort.Slice(histogramKeys, func(i, j int) bool {
+		a, b := histogramKeys[i], histogramKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		return a.method < b.method
+	})
+	out.WriteString("# HELP napi_http_request_duration_seconds HTTP request durations by method and route.\n")
+	out.WriteString("# TYPE napi_http_request_duration_seconds histogram\n")
+	for _, key := range histogramKeys {
+		histogram := requestDurations[key]
+		labels := fmt.Sprintf("method=\"%s\",route=\"%s\"", labelValue(key.method), labelValue(key.route))
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += histogram.counts[i]
+			fmt.Fprintf(&out, "napi_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(&out, "napi_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, histogram.count)
+		fmt.Fprintf(&out, "napi_http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(histogram.sum))
+		fmt.Fprintf(&out, "napi_http_request_duration_seconds_count{%s} %d\n", labels, histogram.count)
+	}
+
+	routes := make([]string, 0, len(rateLimitCounts))
+	for route := range rateLimitCounts {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	out.WriteString("# HELP napi_rate_limited_total Requests rejected by a rate limiter, by route.\n")
+	out.WriteString("# TYPE napi_rate_limited_total counter\n")
+	for _, route := range routes {
+		fmt.Fprintf(&out, "napi_rate_limited_total{route=\"%s\"} %d\n", labelValue(route), rateLimitCounts[route])
+	}
+	metricsMu.Unlock()
+
+	out.WriteString("# HELP napi_websocket_connections Open WebSocket connections across all endpoints.\n")
+	out.WriteString("# TYPE napi_websocket_connections gauge\n")
+	fmt.Fprintf(&out, "napi_websocket_connections %d\n", atomic.LoadInt64(&activeWebSockets))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(out.String()))
+}
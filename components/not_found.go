@@ -0,0 +1,62 @@
+// components/not_found.go
+
+package components
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Methods probed to list what a path accepts in a 405 response
+var probeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+func writeRouteError(w http.ResponseWriter, r *http.Request, status int, body map[string]interface{}) {
+	if id := RequestID(r); id != "" {
+		body["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// NotFoundHandler answers requests for unregistered paths with a JSON 404.
+// The router does not run its middleware for these, so the request ID is
+// assigned here.
+func NotFoundHandler() http.Handler {
+	return RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeRouteError(w, r, http.StatusNotFound, map[string]interface{}{
+			"error": "No route for " + r.URL.Path,
+		})
+	}))
+}
+
+// Lists the methods router accepts for the request's path
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	allowed := []string{}
+	for _, method := range probeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// MethodNotAllowedHandler answers a known path requested with the wrong method
+// with a JSON 405 listing the allowed methods, also sent in the Allow header
+func MethodNotAllowedHandler(router *mux.Router) http.Handler {
+	return RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// OPTIONS is answered for every routed path by PreflightMiddleware
+		allowed := append(allowedMethods(router, r), http.MethodOptions)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeRouteError(w, r, http.StatusMethodNotAllowed, map[string]interface{}{
+			"error":           "Method " + r.Method + " not allowed for " + r.URL.Path,
+			"allowed_methods": allowed,
+		})
+	}))
+}
@@ -0,0 +1,187 @@
+// components/notifications.go
+
+package components
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Number of recent events kept so reconnecting clients can catch up
+	eventHistorySize = 256
+	// Events queued per subscriber before it is considered too slow and dropped
+	subscriberBuffer = 64
+)
+
+// Event is a notification pushed to subscribed WebSocket clients. ID grows
+// monotonically so clients can resume after a reconnect with last_event_id.
+type Event struct {
+	ID    uint64      `json:"id"`
+	Topic string      `json:"topic"`
+	Type  string      `json:"type"`
+	Data  interface{} `json:"data,omitempty"`
+	Time  string      `json:"time"`
+}
+
+type subscriber struct {
+	mu     sync.Mutex
+	topics map[string]bool // empty means every topic
+	events chan Event
+}
+
+func (s *subscriber) wants(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.topics) == 0 || s.topics[topic]
+}
+
+func (s *subscriber) update(subscribe, unsubscribe []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, topic := range subscribe {
+		s.topics[topic] = true
+	}
+	for _, topic := range unsubscribe {
+		delete(s.topics, topic)
+	}
+}
+
+var (
+	hubMu       sync.Mutex
+	subscribers = map[*subscriber]bool{}
+	history     []Event
+	lastEventID uint64
+)
+
+// Broadcast assigns the event an ID and timestamp, records it for replay and
+// queues it for every subscriber of its topic. It never blocks: subscribers
+// whose queue is full are disconnected and can resume from their last ID.
+func Broadcast(event Event) {
+	hubMu.Lock()
+	defer hubMu.Unlock()
+
+	lastEventID++
+	event.ID = lastEventID
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	history = append(history, event)
+	if len(history) > eventHistorySize {
+		history = history[len(history)-eventHistorySize:]
+	}
+
+	for sub := range subscribers {
+		if !sub.wants(event.Topic) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Registers a subscriber and queues the recorded events after lastID
+func subscribe(topics []string, lastID uint64, replay bool) *subscriber {
+	sub := &subscriber{topics: map[string]bool{}, events: make(chan Event, subscriberBuffer+eventHistorySize)}
+	sub.update(topics, nil)
+
+	hubMu.Lock()
+	defer hubMu.Unlock()
+	if replay {
+		for _, event := range history {
+			if event.ID > lastID && sub.wants(event.Topic) {
+				sub.events <- event
+			}
+		}
+	}
+	subscribers[sub] = true
+	return sub
+}
+
+func unsubscribe(sub *subscriber) {
+	hubMu.Lock()
+	defer hubMu.Unlock()
+	if subscribers[sub] {
+		delete(subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Splits a comma-separated topic list, dropping empty entries
+func parseTopics(value string) []string {
+	topics := []string{}
+	for _, topic := range strings.Split(value, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// HandleEvents streams broadcast events to a WebSocket client as JSON text
+// messages. The topics query parameter limits the stream to a comma-separated
+// list of topics and last_event_id replays the recorded events after that ID.
+// Clients change their topics by sending {"subscribe": [...]} or
+// {"unsubscribe": [...]}.
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+	var lastID uint64
+	replay := false
+	if value := r.URL.Query().Get("last_event_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid last_event_id", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+		replay = true
+	}
+
+	session, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("Failed to upgrade websocket: %v", err)
+		return
+	}
+	defer session.Close()
+	conn := session.conn
+
+	sub := subscribe(parseTopics(r.URL.Query().Get("topics")), lastID, replay)
+	defer unsubscribe(sub)
+	log.Printf("User %s subscribed to events from %s", WebSocketUser(r), r.RemoteAddr)
+
+	go func() {
+		defer unsubscribe(sub)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			session.Touch()
+			var request struct {
+				Subscribe   []string `json:"subscribe"`
+				Unsubscribe []string `json:"unsubscribe"`
+			}
+			// Messages that are not subscription changes are ignored
+			if err := json.Unmarshal(message, &request); err != nil {
+				continue
+			}
+			sub.update(request.Subscribe, request.Unsubscribe)
+		}
+	}()
+
+	for event := range sub.events {
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+		session.Touch()
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
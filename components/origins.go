@@ -0,0 +1,50 @@
+// components/origins.go
+
+package components
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Origins other than the server's own that browsers may send credentialed
+// requests from, including WebSocket upgrades
+var (
+	allowedOrigins   = map[string]bool{}
+	allowedOriginsMu sync.RWMutex
+)
+
+// SetAllowedOrigins replaces the origins allowed to make cross-origin
+// requests. Call it before starting the servers.
+func SetAllowedOrigins(origins []string) {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[strings.ToLower(origin)] = true
+	}
+	allowedOriginsMu.Lock()
+	allowedOrigins = allowed
+	allowedOriginsMu.Unlock()
+}
+
+// OriginAllowed reports whether origin was given to SetAllowedOrigins. It
+// has the signature of cors.Options.AllowOriginFunc.
+func OriginAllowed(r *http.Request, origin string) bool {
+	allowedOriginsMu.RLock()
+	defer allowedOriginsMu.RUnlock()
+	return allowedOrigins[strings.ToLower(origin)]
+}
+
+// Accepts WebSocket upgrades without an Origin header, which browsers always
+// send, from the host being connected to or from an allowed origin
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || OriginAllowed(r, origin) {
+		return true
+	}
+	// Same host, as gorilla/websocket checks by default
+	if i := strings.Index(origin, "://"); i >= 0 {
+		return strings.EqualFold(origin[i+3:], r.Host)
+	}
+	return false
+}
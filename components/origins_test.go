@@ -0,0 +1,35 @@
+// components/origins_test.go
+
+package components
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckWebSocketOrigin(t *testing.T) {
+	SetAllowedOrigins([]string{"https://app.example.com"})
+	defer SetAllowedOrigins(nil)
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"https://napi.local:5498", true},
+		{"https://evil.example.com", false},
+		{"https://napi.local:9999", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://napi.local:5498/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkWebSocketOrigin(r); got != tt.want {
+			t.Errorf("checkWebSocketOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
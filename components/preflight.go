@@ -0,0 +1,39 @@
+// components/preflight.go
+
+package components
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// PreflightMiddleware answers OPTIONS requests for any registered path with
+// the methods actually routed for it. No route registers OPTIONS, so these
+// requests reach the router's MethodNotAllowedHandler, which this wraps.
+// The CORS handler in front of it, run with OptionsPassthrough, has already
+// set the origin and allowed headers; the allowed methods are replaced here
+// with the route's own. Paths with no routes fall through to next.
+func PreflightMiddleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed := allowedMethods(router, r)
+			if len(allowed) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed = append(allowed, http.MethodOptions)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			// Only a preflight the CORS handler accepted gets CORS headers
+			if w.Header().Get("Access-Control-Allow-Origin") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// components/recover.go
+
+package components
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+// Tracks whether the handler has started its response, after which a clean
+// error can no longer be sent
+type recoverWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (rw *recoverWriter) WriteHeader(status int) {
+	rw.started = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recoverWriter) Write(p []byte) (int, error) {
+	rw.started = true
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recoverWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.started = true
+		flusher.Flush()
+	}
+}
+
+// RecoverMiddleware catches panics in later handlers, logs them with the
+// request ID and a stack trace, and answers with a 500 JSON error when the
+// response has not been started yet
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// Deliberate aborts are how net/http cancels a response
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			LogRequest(r, "Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			if rw.started {
+				return
+			}
+			body := map[string]string{"error": "Internal server error"}
+			if id := RequestID(r); id != "" {
+				body["request_id"] = id
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(body)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
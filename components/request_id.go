@@ -0,0 +1,54 @@
+// components/request_id.go
+
+package components
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// Incoming IDs are only honored when they are short and free of characters
+// that could corrupt log lines or headers
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,128}$`)
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// RequestIDMiddleware assigns every request an ID, reusing a valid incoming
+// X-Request-ID, stores it in the request context and echoes it in the response
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestID returns the ID assigned to the request, or an empty string
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// LogRequest logs a line prefixed with the request's ID
+func LogRequest(r *http.Request, format string, args ...interface{}) {
+	log.Printf("[%s] %s", RequestID(r), fmt.Sprintf(format, args...))
+}
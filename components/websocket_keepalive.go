@@ -0,0 +1,107 @@
+// components/websocket_keepalive.go
+
+package components
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Keepalive settings shared by every WebSocket endpoint. A client that does
+// not answer a ping within wsPongTimeout is disconnected; one that exchanges
+// no messages for wsIdleTimeout is closed as idle. Zero disables either.
+var (
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 10 * time.Second
+	wsIdleTimeout  time.Duration
+)
+
+// ConfigureWebSocketKeepalive changes the ping interval, the time allowed for
+// the matching pong and the idle timeout. Call it before starting the server.
+func ConfigureWebSocketKeepalive(pingInterval, pongTimeout, idleTimeout time.Duration) {
+	wsPingInterval = pingInterval
+	wsPongTimeout = pongTimeout
+	wsIdleTimeout = idleTimeout
+}
+
+// wsSession is an upgraded connection kept alive with pings and counted in
+// the napi_websocket_connections gauge until closed
+type wsSession struct {
+	conn       *websocket.Conn
+	lastActive int64
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// Upgrades the request and starts the keepalive. The handler must keep
+// reading from the connection, since pongs are processed by its reads, and
+// call Close when done.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsSession, error) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	session := &wsSession{conn: conn, done: make(chan struct{})}
+	session.Touch()
+	atomic.AddInt64(&activeWebSockets, 1)
+
+	if wsPingInterval > 0 {
+		conn.SetReadDeadline(time.Now().Add(wsPingInterval + wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPingInterval + wsPongTimeout))
+		})
+	}
+	go session.keepalive()
+	return session, nil
+}
+
+// Touch records application traffic, postponing the idle timeout
+func (s *wsSession) Touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+// Close stops the keepalive and closes the connection; it is safe to call
+// more than once
+func (s *wsSession) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.conn.Close()
+		atomic.AddInt64(&activeWebSockets, -1)
+	})
+}
+
+func (s *wsSession) keepalive() {
+	interval := wsPingInterval
+	if interval <= 0 {
+		interval = wsIdleTimeout / 4
+	}
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		// WriteControl may be used alongside the handler's own writes
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+		if wsIdleTimeout > 0 && idle > wsIdleTimeout {
+			s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "idle timeout"), time.Now().Add(time.Second))
+			s.conn.Close()
+			return
+		}
+		if wsPingInterval > 0 {
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPongTimeout)); err != nil {
+				s.conn.Close()
+				return
+			}
+		}
+	}
+}
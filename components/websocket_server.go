@@ -3,6 +3,8 @@
 package components
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os/exec"
@@ -13,20 +15,39 @@ import (
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin: checkWebSocketOrigin,
 }
 
 var SHELL_TYPE = "bash"
 
+// WebSocketAuthFunc validates an upgrade request and returns the username
+type WebSocketAuthFunc func(r *http.Request) (string, error)
+
+// Client is an authenticated WebSocket connection
+type Client struct {
+	User string
+	Conn *websocket.Conn
+}
+
+type webSocketUserKey struct{}
+
+// WebSocketUser returns the user authenticated for a WebSocket upgrade request
+func WebSocketUser(r *http.Request) string {
+	user, _ := r.Context().Value(webSocketUserKey{}).(string)
+	return user
+}
+
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	session, err := upgradeWebSocket(w, r)
 	if err != nil {
 		log.Printf("Failed to upgrade websocket: %v", err)
 		return
 	}
-	defer conn.Close()
+	defer session.Close()
+	conn := session.conn
+
+	client := &Client{User: WebSocketUser(r), Conn: conn}
+	log.Printf("User %s opened a terminal session from %s", client.User, r.RemoteAddr)
 
 	var shell *exec.Cmd
 	switch SHELL_TYPE {
@@ -57,6 +78,7 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				return
 			}
+			session.Touch()
 			conn.WriteMessage(websocket.TextMessage, buf[:n])
 		}
 	}()
@@ -66,22 +88,58 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			break
 		}
+		session.Touch()
 		ptyFile.Write(msg)
 	}
 }
 
-func StartWebSocketServer() {
+// StartWebSocketServer serves the terminal WebSocket on addr (host:port).
+// Upgrades are refused with 401 unless authenticate accepts the request.
+func StartWebSocketServer(addr string, authenticate WebSocketAuthFunc) {
+	registerWebSocketHandler(authenticate)
+	log.Printf("WebSocket server is listening on %s (Shell Type: %s)", addr, SHELL_TYPE)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+// StartWebSocketServerTLS is StartWebSocketServer serving wss:// with the given
+// certificate and key files
+func StartWebSocketServerTLS(addr, certFile, keyFile string, authenticate WebSocketAuthFunc) {
+	registerWebSocketHandler(authenticate)
+	log.Printf("WebSocket server is listening on %s with TLS (Shell Type: %s)", addr, SHELL_TYPE)
+	log.Fatal(http.ListenAndServeTLS(addr, certFile, keyFile, nil))
+}
+
+// ErrWebSocketForbidden is returned by a WebSocketAuthFunc to refuse an
+// authenticated user who lacks permission, answered with 403 instead of 401
+var ErrWebSocketForbidden = errors.New("forbidden")
+
+func registerWebSocketHandler(authenticate WebSocketAuthFunc) {
+	HandleWebSocketRoute("/ws", authenticate, HandleWebSocket)
+}
+
+// HandleWebSocketRoute registers an additional WebSocket endpoint on the
+// WebSocket server, guarded by its own authentication function. Call it
+// before starting the server.
+func HandleWebSocketRoute(pattern string, authenticate WebSocketAuthFunc, handler http.HandlerFunc) {
 	corsOptions := cors.Options{
-		AllowedOrigins: []string{"*"},
+		AllowOriginFunc: OriginAllowed,
 	}
 	corsMiddleware := cors.New(corsOptions).Handler
 
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		corsMiddleware(http.HandlerFunc(HandleWebSocket)).ServeHTTP(w, r)
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticate(r)
+		if err != nil {
+			log.Printf("Rejected WebSocket upgrade of %s from %s: %v", pattern, r.RemoteAddr, err)
+			if errors.Is(err, ErrWebSocketForbidden) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), webSocketUserKey{}, user))
+		corsMiddleware(handler).ServeHTTP(w, r)
 	})
-
-	log.Printf("WebSocket server is running on ws://localhost:5492 (Shell Type: %s)", SHELL_TYPE)
-	log.Fatal(http.ListenAndServe(":5498", nil))
 }
 
 // tmuxCommand creates or attaches to a tmux session named 'nuc-rev'
@@ -12,6 +12,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/msteinert/pam v1.2.0
 	github.com/ulule/limiter/v3 v3.11.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
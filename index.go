@@ -74,6 +74,15 @@ func init() {
 
     // Set up logging to file
     setupLogging()
+
+    // Read the route settings and the allowed origins, keeping the default of
+    // each invalid value
+    settings := &components.Config{}
+    routes.LoadSettings(settings)
+    components.SetAllowedOrigins(settings.Origins("ALLOWED_ORIGINS"))
+    for _, problem := range settings.Problems() {
+        log.Printf("Ignoring invalid configuration: %s", problem)
+    }
 }
 
 // setupLogging initializes logging to a file, appending to it if it exists
@@ -95,7 +104,7 @@ func main() {
         // }
         // serverUsername := currentUser.Username
 
-        AllowedOrigins:   []string{"*"},
+        AllowOriginFunc:  components.OriginAllowed,
         AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
         AllowedHeaders:   []string{"Content-Type", "Authorization"},
         AllowCredentials: true,
@@ -184,7 +193,7 @@ func main() {
 
     // Start WebSocket server
     go func() {
-        components.StartWebSocketServer()
+        components.StartWebSocketServer(":"+websocketPort, authenticateWebSocket)
     }()
 
     // Block the main goroutine
@@ -344,6 +353,25 @@ func isAuthenticated(next http.Handler) http.Handler {
     })
 }
 
+// Authenticates a WebSocket upgrade from an access token in the query string
+func authenticateWebSocket(r *http.Request) (string, error) {
+    token, err := jwt.Parse(r.URL.Query().Get("token"), func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+            return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+        }
+        return publicKey, nil
+    })
+    if err != nil || !token.Valid {
+        return "", fmt.Errorf("invalid token")
+    }
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok {
+        return "", fmt.Errorf("invalid token claims")
+    }
+    user, _ := claims["username"].(string)
+    return user, nil
+}
+
 // Handles requests to retrieve the version
 func versionHandler(w http.ResponseWriter, r *http.Request) {
     user, ok := r.Context().Value("user").(string)
@@ -2,12 +2,20 @@ package main
 
 import (
     "context"
+    "crypto/rand"
     "crypto/rsa"
+    "crypto/subtle"
+    "encoding/hex"
     "encoding/json"
     "fmt"
     "log"
+    "net"
     "net/http"
     "os"
+    "runtime"
+    "runtime/debug"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/go-chi/cors"
@@ -28,9 +36,28 @@ var (
     V_LOG       bool
     privateKey  *rsa.PrivateKey
     publicKey   *rsa.PublicKey
+    jwtSecret   []byte
     username    string
     password    string
+    adminUsers  = map[string]bool{}
     tokenExpiry time.Duration = 30 * 24 * time.Hour // Default token expiration is one month
+    wsTokenExpiry  time.Duration = time.Minute
+    cookieSecure   bool          = true
+    cookieSameSite http.SameSite = http.SameSiteStrictMode
+    loginLockout   *components.LoginLockout
+)
+
+// Set at build time, e.g.
+// go build -ldflags "-X main.gitCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" nuc.go
+var (
+    gitCommit string
+    buildTime string
+)
+
+const (
+    sessionCookieName = "napi_session"
+    csrfCookieName    = "napi_csrf"
+    csrfHeaderName    = "X-CSRF-Token"
 )
 
 func init() {
@@ -39,9 +66,16 @@ func init() {
         log.Printf("Error loading .env file: %v", err)
     }
 
-    // Load user credentials from environment variables
-    username = os.Getenv("USERNAME")
-    password = os.Getenv("PASSWORD")
+    // Read every setting up front and refuse to start on invalid ones,
+    // listing all of them so they can be fixed in one go
+    settings := &components.Config{}
+    loadConfig(settings)
+    if problems := settings.Problems(); len(problems) > 0 {
+        for _, problem := range problems {
+            log.Printf("Invalid configuration: %s", problem)
+        }
+        log.Fatalf("Refusing to start with %d configuration problem(s)", len(problems))
+    }
 
     // Load version from environment variables
     VERSION = "0.0.3"
@@ -50,6 +84,150 @@ func init() {
     LOG = true
     V_LOG = true
 
+    // Sign tokens with HS256 and JWT_SECRET when it is set, RS256 with the
+    // key pair otherwise
+    if jwtSecret == nil {
+        loadKeys()
+    }
+
+    // Set up logging to file
+    setupLogging()
+
+    log.Printf("Effective configuration:")
+    for _, line := range settings.Summary() {
+        log.Printf("  %s", line)
+    }
+}
+
+// Settings read from the environment by loadConfig for main
+var config struct {
+    port            string
+    websocketPort   string
+    metricsPort     string
+    redirectPort    string
+    bindAddr        string
+    tlsCert         string
+    tlsKey          string
+    maxRequestBytes int64
+    gzipMinSize     int
+    envelopeDefault bool
+    generalRate     limiter.Rate
+    loginRate       limiter.Rate
+    systemRate      limiter.Rate
+    allowedOrigins  []string
+    wsPingInterval  time.Duration
+    wsPongTimeout   time.Duration
+    wsIdleTimeout   time.Duration
+}
+
+// Reads and validates the settings taken from the environment, recording
+// every invalid value in c rather than stopping at the first
+func loadConfig(c *components.Config) {
+    // Load user credentials from environment variables
+    username = c.String("USERNAME", "")
+    password = c.Secret("PASSWORD")
+    if username == "" {
+        c.Problemf("USERNAME is required")
+    }
+    if password == "" {
+        c.Problemf("PASSWORD is required")
+    }
+    // Admins are named explicitly; everyone else, the USERNAME account
+    // included, gets the user role
+    if value := c.String("ADMIN_USERS", ""); value != "" {
+        for _, name := range strings.Split(value, ",") {
+            name = strings.TrimSpace(name)
+            if name == "" {
+                c.Problemf("ADMIN_USERS=%q: expected a comma-separated list of usernames", value)
+                continue
+            }
+            adminUsers[name] = true
+        }
+    }
+    if secret := c.Secret("JWT_SECRET"); secret != "" {
+        if len(secret) < 32 {
+            c.Problemf("JWT_SECRET must be at least 32 characters long")
+        }
+        jwtSecret = []byte(secret)
+    }
+
+    // Load session cookie flags, defaulting to Secure and SameSite=Strict
+    cookieSecure = c.Bool("COOKIE_SECURE", true)
+    switch c.Choice("COOKIE_SAMESITE", "strict", "strict", "lax", "none") {
+    case "strict":
+        cookieSameSite = http.SameSiteStrictMode
+    case "lax":
+        cookieSameSite = http.SameSiteLaxMode
+    case "none":
+        cookieSameSite = http.SameSiteNoneMode
+        if !cookieSecure {
+            log.Printf("COOKIE_SAMESITE=none without COOKIE_SECURE is rejected by most browsers")
+        }
+    }
+
+    // Lock a username for LOGIN_LOCKOUT_DURATION after LOGIN_LOCKOUT_ATTEMPTS
+    // consecutive failures within LOGIN_LOCKOUT_WINDOW; 0 attempts disables it
+    lockoutAttempts := c.Int("LOGIN_LOCKOUT_ATTEMPTS", 5, 0)
+    lockoutWindow := c.Duration("LOGIN_LOCKOUT_WINDOW", 15*time.Minute, false)
+    lockoutDuration := c.Duration("LOGIN_LOCKOUT_DURATION", 15*time.Minute, false)
+    loginLockout = components.NewLoginLockout(lockoutAttempts, lockoutWindow, lockoutDuration)
+
+    config.maxRequestBytes = c.Int64("MAX_REQUEST_BYTES", 1<<20, 1)
+    config.gzipMinSize = c.Int("GZIP_MIN_SIZE", 1024, 0)
+    config.envelopeDefault = c.Bool("RESPONSE_ENVELOPE", false)
+
+    config.generalRate = rateFromEnv(c, "GENERAL", 60, time.Minute)
+    config.loginRate = rateFromEnv(c, "LOGIN", 40, time.Minute)
+    config.systemRate = rateFromEnv(c, "SYSTEM", 70, time.Minute)
+
+    config.port = c.Port("PORT", "5499")
+    config.websocketPort = c.Port("WEBSOCKET_PORT", "5498")
+    config.metricsPort = c.Port("METRICS_PORT", "")
+    config.redirectPort = c.Port("HTTP_REDIRECT_PORT", "")
+    // Every listener binds to the same address, so no two may share a port
+    listeners := map[string]string{}
+    for _, listener := range []struct{ name, port string }{
+        {"PORT", config.port},
+        {"WEBSOCKET_PORT", config.websocketPort},
+        {"METRICS_PORT", config.metricsPort},
+        {"HTTP_REDIRECT_PORT", config.redirectPort},
+    } {
+        if listener.port == "" {
+            continue
+        }
+        if other, taken := listeners[listener.port]; taken {
+            c.Problemf("%s and %s are both set to port %s", other, listener.name, listener.port)
+            continue
+        }
+        listeners[listener.port] = listener.name
+    }
+    config.bindAddr = c.String("BIND_ADDR", "")
+
+    config.tlsCert = c.String("TLS_CERT", "")
+    config.tlsKey = c.String("TLS_KEY", "")
+    if (config.tlsCert == "") != (config.tlsKey == "") {
+        c.Problemf("TLS_CERT and TLS_KEY must be set together")
+    }
+    for _, file := range []struct{ name, path string }{{"TLS_CERT", config.tlsCert}, {"TLS_KEY", config.tlsKey}} {
+        if file.path == "" {
+            continue
+        }
+        if _, err := os.Stat(file.path); err != nil {
+            c.Problemf("%s: %v", file.name, err)
+        }
+    }
+
+    config.allowedOrigins = c.Origins("ALLOWED_ORIGINS")
+
+    config.wsPingInterval = c.Duration("WS_PING_INTERVAL", 30*time.Second, false)
+    config.wsPongTimeout = c.Duration("WS_PONG_TIMEOUT", 10*time.Second, false)
+    config.wsIdleTimeout = c.Duration("WS_IDLE_TIMEOUT", 0, true)
+
+    routes.LoadSettings(c)
+}
+
+// Loads the RSA key pair used to sign and verify tokens
+func loadKeys() {
     // Load the private key
     privateKeyData, err := os.ReadFile("keys/private_key.pem")
     if err != nil {
@@ -69,9 +247,6 @@ func init() {
     if err != nil {
         log.Fatalf("Error parsing public key: %v", err)
     }
-
-    // Set up logging to file
-    setupLogging()
 }
 
 // setupLogging initializes logging to a file, appending to it if it exists
@@ -93,35 +268,64 @@ func pingHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+    components.SetAllowedOrigins(config.allowedOrigins)
+
     corsOptions := cors.Options{
-        AllowedOrigins:   []string{"*"},
+        // Only the origins in ALLOWED_ORIGINS, since the session cookie is
+        // sent with credentialed requests
+        AllowOriginFunc:  components.OriginAllowed,
         AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Content-Type", "Authorization"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "X-CSRF-Token", "X-Request-ID", components.EnvelopeHeader},
+        ExposedHeaders:   []string{"X-Request-ID", components.EnvelopeHeader},
         AllowCredentials: true,
+        // Preflight requests are answered by components.PreflightMiddleware
+        OptionsPassthrough: true,
     }
 
     r := mux.NewRouter()
 
+    // JSON errors for unknown paths and methods, like every other error.
+    // Router middleware does not run for these, so CORS is applied here.
+    r.NotFoundHandler = cors.Handler(corsOptions)(components.NotFoundHandler())
+    // OPTIONS requests never match a route and land here too, where they
+    // are answered with the path's registered methods
+    r.MethodNotAllowedHandler = cors.Handler(corsOptions)(components.PreflightMiddleware(r)(components.MethodNotAllowedHandler(r)))
+
+    // Assign a request ID before anything else so every log line can carry it
+    r.Use(components.RequestIDMiddleware)
+
+    // Record request counts and durations for /metrics
+    r.Use(components.MetricsMiddleware)
+
+    // Cap request bodies and query strings at MAX_REQUEST_BYTES (default 1 MiB);
+    // archive uploads have their own, larger limit
+    r.Use(components.BodyLimitMiddleware(config.maxRequestBytes, "/io/system/extract"))
+
     // Apply CORS middleware
     r.Use(cors.Handler(corsOptions))
 
     // Apply security headers middleware
     r.Use(securityHeadersMiddleware)
 
+    // Compress responses above GZIP_MIN_SIZE bytes (default 1024)
+    r.Use(components.GzipMiddleware(config.gzipMinSize))
+
+    // Wrap JSON responses in {"ok": ..., "data": ...} when RESPONSE_ENVELOPE
+    // is true or the client asks with X-Response-Envelope: 1. The legacy
+    // shapes stay the default until clients have moved over.
+    r.Use(components.EnvelopeMiddleware(config.envelopeDefault, "/openapi.json", "/metrics"))
+
+    // Turn handler panics into a logged 500 instead of a dropped connection
+    r.Use(components.RecoverMiddleware)
+
     // General rate limiter configuration for all routes except login
-    generalRate := limiter.Rate{
-        Period: 1 * time.Minute,
-        Limit:  60,
-    }
+    generalRate := config.generalRate
     generalLimiterStore := memory.NewStore()
     generalLimiter := limiter.New(generalLimiterStore, generalRate)
     generalLimiterMiddleware := stdlib.NewMiddleware(generalLimiter)
 
     // Rate limiter configuration for login route
-    loginRate := limiter.Rate{
-        Period: 1 * time.Minute,
-        Limit:  40,
-    }
+    loginRate := config.loginRate
     loginLimiterStore := memory.NewStore()
     loginLimiter := limiter.New(loginLimiterStore, loginRate)
     loginLimiterMiddleware := stdlib.NewMiddleware(loginLimiter)
@@ -129,147 +333,309 @@ func main() {
     // Ping endpoint
     r.HandleFunc("/ping", pingHandler).Methods("GET")
 
+    // Probe for systemctl, journalctl and at so missing tools show up in the
+    // log and in /healthz instead of as confusing request failures
+    routes.CheckCapabilities()
+    r.HandleFunc("/healthz", routes.HealthzHandler).Methods("GET")
+
+    // OpenAPI document generated from the registered routes
+    r.Handle("/openapi.json", routes.OpenAPIHandler(r, VERSION)).Methods("GET")
+
+    // Prometheus metrics, unauthenticated; served on METRICS_PORT instead
+    // when set so they can be kept off the public listener
+    metricsPort := config.metricsPort
+    if metricsPort == "" {
+        r.HandleFunc("/metrics", components.MetricsHandler).Methods("GET")
+    }
+
     // Login endpoint with specific rate limiter
-    r.Handle("/login", loginLimiterMiddleware.Handler(http.HandlerFunc(loginHandler))).Methods("POST", "OPTIONS")
+    r.Handle("/login", loginLimiterMiddleware.Handler(components.RequireJSONMiddleware(http.HandlerFunc(loginHandler)))).Methods("POST")
 
     // Protected routes
-    r.Handle("/version", isAuthenticated(http.HandlerFunc(versionHandler))).Methods("GET", "OPTIONS")
-
-    // Handle preflight requests
-    r.HandleFunc("/login", optionsHandler).Methods("OPTIONS")
-    r.HandleFunc("/version", optionsHandler).Methods("OPTIONS")
+    r.Handle("/version", isAuthenticated(http.HandlerFunc(versionHandler))).Methods("GET")
+    r.Handle("/ws-token", isAuthenticated(http.HandlerFunc(wsTokenHandler))).Methods("POST")
+    r.Handle("/whoami", isAuthenticated(http.HandlerFunc(whoamiHandler))).Methods("GET")
 
     // Apply general rate limiting to all routes except login and version
     r.Use(generalLimiterMiddleware.Handler)
 
+    // Signed download links carry their own authorization instead of a
+    // session, so this route is matched ahead of the authenticated /io routes
+    r.HandleFunc("/io/system/download", routes.SignedDownload).Methods("GET")
+
     // Register system and docker routes with specific rate limiter
     systemRouter := r.PathPrefix("/io").Subrouter()
-    systemRate := limiter.Rate{
-        Period: 1 * time.Minute,
-        Limit:  70,
-    }
+    systemRate := config.systemRate
     systemLimiterStore := memory.NewStore()
     systemLimiter := limiter.New(systemLimiterStore, systemRate)
     systemRouter.Use(stdlib.NewMiddleware(systemLimiter).Handler)
     systemRouter.Use(isAuthenticated)
+    routes.SetSystemRate(systemRate)
     routes.RegisterSystemRoutes(systemRouter)
     routes.DockerHandler(systemRouter)
     routes.NestHandler(systemRouter)
     
 
-    port := os.Getenv("PORT")
-    if port == "" {
-        port = "5499"
-    }
+    port := config.port
+    websocketPort := config.websocketPort
 
-    websocketPort := os.Getenv("WEBSOCKET_PORT")
-    if websocketPort == "" {
-        websocketPort = "5498"
-    }
+    // Address to bind both servers to, empty for all interfaces
+    bindAddr := config.bindAddr
+    apiAddr := net.JoinHostPort(bindAddr, port)
+    websocketAddr := net.JoinHostPort(bindAddr, websocketPort)
+
+    // Serve HTTPS directly when a certificate and key are configured
+    tlsCert := config.tlsCert
+    tlsKey := config.tlsKey
+    useTLS := tlsCert != ""
 
     // Start HTTP API server
     go func() {
-        log.Printf("API server is running on port %s", port)
-        log.Fatal(http.ListenAndServe(":"+port, r))
+        if useTLS {
+            log.Printf("API server is listening on %s with TLS", apiAddr)
+            log.Fatal(http.ListenAndServeTLS(apiAddr, tlsCert, tlsKey, r))
+        }
+        log.Printf("API server is listening on %s", apiAddr)
+        log.Fatal(http.ListenAndServe(apiAddr, r))
     }()
 
-    // Start WebSocket server
+    // Redirect plain HTTP to the HTTPS port
+    if redirectPort := config.redirectPort; useTLS && redirectPort != "" {
+        redirectAddr := net.JoinHostPort(bindAddr, redirectPort)
+        go func() {
+            log.Printf("Redirecting HTTP on %s to HTTPS port %s", redirectAddr, port)
+            log.Fatal(http.ListenAndServe(redirectAddr, httpsRedirect(port)))
+        }()
+    }
+
+    // Start the separate metrics listener
+    if metricsPort != "" {
+        metricsAddr := net.JoinHostPort(bindAddr, metricsPort)
+        metricsMux := http.NewServeMux()
+        metricsMux.HandleFunc("/metrics", components.MetricsHandler)
+        go func() {
+            log.Printf("Metrics server is listening on %s", metricsAddr)
+            log.Fatal(http.ListenAndServe(metricsAddr, metricsMux))
+        }()
+    }
+
+    // Ping WebSocket clients every WS_PING_INTERVAL, dropping those that do
+    // not answer within WS_PONG_TIMEOUT, and close connections without
+    // traffic for WS_IDLE_TIMEOUT when set
+    components.ConfigureWebSocketKeepalive(
+        config.wsPingInterval,
+        config.wsPongTimeout,
+        config.wsIdleTimeout,
+    )
+
+    // Notification events for any authenticated client
+    components.HandleWebSocketRoute("/ws/events", authenticateWebSocket, components.HandleEvents)
+
+    // Live view of the server's own log, for admins only
+    components.HandleWebSocketRoute("/ws/serve-log", authenticateAdminWebSocket, components.TailLogHandler("serve.log"))
+
+    // Start WebSocket server. The /ws terminal runs a shell as the server's
+    // own user, so only admins may open it.
     go func() {
-        components.StartWebSocketServer()
+        if useTLS {
+            components.StartWebSocketServerTLS(websocketAddr, tlsCert, tlsKey, authenticateAdminWebSocket)
+            return
+        }
+        components.StartWebSocketServer(websocketAddr, authenticateAdminWebSocket)
     }()
 
     // Block the main goroutine
     select {}
 }
 
+// Builds a rate from <prefix>_RATE_LIMIT (requests) and <prefix>_RATE_PERIOD
+// (a duration such as 1m or 30s), falling back to the given defaults
+func rateFromEnv(c *components.Config, prefix string, defaultLimit int64, defaultPeriod time.Duration) limiter.Rate {
+    return limiter.Rate{
+        Limit:  c.Int64(prefix+"_RATE_LIMIT", defaultLimit, 1),
+        Period: c.Duration(prefix+"_RATE_PERIOD", defaultPeriod, false),
+    }
+}
+
+// Redirects every request to the same host and path on the HTTPS port
+func httpsRedirect(httpsPort string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        host := r.Host
+        if h, _, err := net.SplitHostPort(host); err == nil {
+            host = h
+        }
+        if httpsPort != "443" {
+            host = net.JoinHostPort(host, httpsPort)
+        }
+        target := "https://" + host + r.URL.RequestURI()
+        http.Redirect(w, r, target, http.StatusPermanentRedirect)
+    })
+}
+
 // Handles the login requests and validates the user credentials
 func loginHandler(w http.ResponseWriter, r *http.Request) {
     var creds struct {
         Username string `json:"username"`
         Password string `json:"password"`
+        Mode     string `json:"mode"`
     }
 
     // Decode the JSON request payload
     err := json.NewDecoder(r.Body).Decode(&creds)
     if err != nil {
+        if components.IsBodyTooLarge(err) {
+            http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+            return
+        }
         http.Error(w, "Invalid request payload", http.StatusBadRequest)
         return
     }
 
+    // Refuse locked usernames before looking at the password, so guesses
+    // during the cooldown learn nothing
+    if remaining, locked := loginLockout.Locked(creds.Username); locked {
+        w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+        http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+        return
+    }
+
     // Validate the provided credentials
     if creds.Username != username || creds.Password != password {
+        if loginLockout.Failure(creds.Username) {
+            components.LogRequest(r, "Locked username %q for %s after repeated failed logins, last from IP %s", creds.Username, loginLockout.Cooldown, r.RemoteAddr)
+        }
         http.Error(w, "Invalid username or password", http.StatusUnauthorized)
         return
     }
+    loginLockout.Success(creds.Username)
+
+    // Every login gets its own session ID, kept across token refreshes, so
+    // that several clients of one user are told apart
+    sessionID, err := randomToken()
+    if err != nil {
+        http.Error(w, "Error generating session", http.StatusInternalServerError)
+        return
+    }
+
+    // Stateless clients such as CLI tools only get a bearer token, no
+    // cookies and no CSRF token since they never send cookies
+    if creds.Mode == "token" {
+        accessToken, err := createToken(creds.Username, "", sessionID, tokenExpiry)
+        if err != nil {
+            http.Error(w, "Error generating access token", http.StatusInternalServerError)
+            return
+        }
+        if V_LOG {
+            components.LogRequest(r, "User %s logged in for a bearer token at %s from IP %s", creds.Username, time.Now().Format(time.RFC3339), r.RemoteAddr)
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "message":      "Login successful",
+            "access_token": accessToken,
+            "token_type":   "Bearer",
+            "expires_in":   int(tokenExpiry.Seconds()),
+        })
+        return
+    }
+    if creds.Mode != "" && creds.Mode != "cookie" {
+        http.Error(w, "mode must be cookie or token", http.StatusBadRequest)
+        return
+    }
+
+    // Create the CSRF token bound to this session
+    csrfToken, err := randomToken()
+    if err != nil {
+        http.Error(w, "Error generating CSRF token", http.StatusInternalServerError)
+        return
+    }
 
     // Create access token
-    accessToken, err := createToken(creds.Username, tokenExpiry)
+    accessToken, err := createToken(creds.Username, csrfToken, sessionID, tokenExpiry)
     if err != nil {
         http.Error(w, "Error generating access token", http.StatusInternalServerError)
         return
     }
 
+    setSessionCookie(w, accessToken)
+    setCSRFCookie(w, csrfToken)
+
     if V_LOG {
-        log.Printf("User %s logged in at %s from IP %s", creds.Username, time.Now().Format(time.RFC3339), r.RemoteAddr)
+        components.LogRequest(r, "User %s logged in at %s from IP %s", creds.Username, time.Now().Format(time.RFC3339), r.RemoteAddr)
     }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
         "message":      "Login successful",
         "access_token": accessToken,
+        "csrf_token":   csrfToken,
     })
 }
 
 // Middleware to check if the user is authenticated and reset token expiration
 func isAuthenticated(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var tokenString string
+        fromCookie := false
         authHeader := r.Header.Get("Authorization")
         if authHeader == "" {
-            log.Printf("Missing Authorization header")
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
-
-        // Extract the token from the "Bearer " prefix
-        if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-            log.Printf("Malformed Authorization header")
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
-
-        tokenString := authHeader[7:]
-        token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-            if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-                log.Printf("Unexpected signing method: %v", token.Header["alg"])
-                return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+            // Fall back to the session cookie set at login
+            cookie, err := r.Cookie(sessionCookieName)
+            if err != nil || cookie.Value == "" {
+                components.LogRequest(r, "Missing Authorization header")
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
             }
-            return publicKey, nil
-        })
+            tokenString = cookie.Value
+            fromCookie = true
+        } else {
+            // Extract the token from the "Bearer " prefix
+            if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+                components.LogRequest(r, "Malformed Authorization header")
+                http.Error(w, "Unauthorized", http.StatusUnauthorized)
+                return
+            }
+            tokenString = authHeader[7:]
+        }
 
+        claims, err := parseToken(r, tokenString)
         if err != nil {
-            log.Printf("Error parsing token: %v", err)
             http.Error(w, "Unauthorized", http.StatusUnauthorized)
             return
         }
 
-        if !token.Valid {
-            log.Printf("Invalid token")
+        // Purpose-bound tokens such as WebSocket tickets are not session tokens
+        if _, ok := claims["purpose"]; ok {
+            components.LogRequest(r, "Token with purpose %v used as session token", claims["purpose"])
             http.Error(w, "Unauthorized", http.StatusUnauthorized)
             return
         }
 
-        claims, ok := token.Claims.(jwt.MapClaims)
-        if !ok {
-            log.Printf("Invalid token claims")
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
+        components.LogRequest(r, "Authenticated user %v", claims["username"])
+
+        // Cookie-authenticated state-changing requests must echo the CSRF
+        // token issued at login; bearer tokens are not sent automatically
+        // by browsers and are exempt
+        csrfToken, _ := claims["csrf"].(string)
+        if fromCookie && isStateChanging(r.Method) {
+            provided := r.Header.Get(csrfHeaderName)
+            if csrfToken == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(csrfToken)) != 1 {
+                components.LogRequest(r, "Missing or invalid CSRF token")
+                http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+                return
+            }
         }
 
-        log.Printf("Token claims: %v", claims)
+        // Tokens issued before session IDs were added are told apart by
+        // their CSRF token instead
+        sessionID, _ := claims["sid"].(string)
+        if sessionID == "" {
+            sessionID = csrfToken
+        }
 
         // Reset the token expiration time
         username := claims["username"].(string)
-        newToken, err := createToken(username, tokenExpiry)
+        expiresAt := time.Now().Add(tokenExpiry)
+        newToken, err := createToken(username, csrfToken, sessionID, tokenExpiry)
         if err != nil {
             http.Error(w, "Error resetting token expiration", http.StatusInternalServerError)
             return
@@ -277,51 +643,260 @@ func isAuthenticated(next http.Handler) http.Handler {
 
         // Set the new token in the response header
         w.Header().Set("Authorization", "Bearer "+newToken)
+        if fromCookie {
+            setSessionCookie(w, newToken)
+        }
 
         // Add claims to the request context
         ctx := context.WithValue(r.Context(), "user", username)
+        role, _ := claims["role"].(string)
+        ctx = context.WithValue(ctx, "role", role)
+        ctx = context.WithValue(ctx, "expires", expiresAt)
+        ctx = context.WithValue(ctx, "session", sessionID)
         next.ServeHTTP(w, r.WithContext(ctx))
     })
 }
 
+// Parses and validates a signed token, returning its claims
+func parseToken(r *http.Request, tokenString string) (jwt.MapClaims, error) {
+    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+        // Only the configured algorithm is accepted, so an HS256 token can
+        // never be checked against the RSA public key or the other way round
+        if jwtSecret != nil {
+            if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+                components.LogRequest(r, "Unexpected signing method: %v", token.Header["alg"])
+                return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+            }
+            return jwtSecret, nil
+        }
+        if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+            components.LogRequest(r, "Unexpected signing method: %v", token.Header["alg"])
+            return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+        }
+        return publicKey, nil
+    })
+
+    if err != nil {
+        components.LogRequest(r, "Error parsing token: %v", err)
+        return nil, err
+    }
+
+    if !token.Valid {
+        components.LogRequest(r, "Invalid token")
+        return nil, fmt.Errorf("invalid token")
+    }
+
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok {
+        components.LogRequest(r, "Invalid token claims")
+        return nil, fmt.Errorf("invalid token claims")
+    }
+
+    return claims, nil
+}
+
+// Handles requests for a short-lived token authorizing one WebSocket upgrade
+func wsTokenHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := r.Context().Value("user").(string)
+    if !ok {
+        components.LogRequest(r, "User context not found")
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    tokenString, err := signToken(jwt.MapClaims{
+        "username": user,
+        "role":     roleFor(user),
+        "purpose":  "ws",
+        "exp":      time.Now().Add(wsTokenExpiry).Unix(),
+    })
+    if err != nil {
+        http.Error(w, "Error generating WebSocket token", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "token":      tokenString,
+        "expires_in": int(wsTokenExpiry.Seconds()),
+    })
+}
+
+// Authenticates a WebSocket upgrade from a ws-token in the query string or
+// from the session cookie, returning the username
+func authenticateWebSocket(r *http.Request) (string, error) {
+    tokenString := r.URL.Query().Get("token")
+    purpose := "ws"
+    if tokenString == "" {
+        cookie, err := r.Cookie(sessionCookieName)
+        if err != nil || cookie.Value == "" {
+            return "", fmt.Errorf("missing WebSocket token or session cookie")
+        }
+        tokenString = cookie.Value
+        purpose = ""
+    }
+
+    claims, err := parseToken(r, tokenString)
+    if err != nil {
+        return "", err
+    }
+    if claimed, _ := claims["purpose"].(string); claimed != purpose {
+        return "", fmt.Errorf("token is not valid for WebSocket upgrades")
+    }
+    user, ok := claims["username"].(string)
+    if !ok || user == "" {
+        return "", fmt.Errorf("token has no username")
+    }
+    return user, nil
+}
+
+// Like authenticateWebSocket, but refuses users other than the admin
+func authenticateAdminWebSocket(r *http.Request) (string, error) {
+    user, err := authenticateWebSocket(r)
+    if err != nil {
+        return "", err
+    }
+    if roleFor(user) != "admin" {
+        return "", fmt.Errorf("user %s is not an admin: %w", user, components.ErrWebSocketForbidden)
+    }
+    return user, nil
+}
+
 // Handles requests to retrieve the version
 func versionHandler(w http.ResponseWriter, r *http.Request) {
     user, ok := r.Context().Value("user").(string)
     if !ok {
-        log.Printf("User context not found")
+        components.LogRequest(r, "User context not found")
         http.Error(w, "Unauthorized", http.StatusUnauthorized)
         return
     }
 
-    log.Printf("User %s accessed version endpoint", user)
+    components.LogRequest(r, "User %s accessed version endpoint", user)
 
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{
+    json.NewEncoder(w).Encode(map[string]interface{}{
         "version": VERSION,
         "user":    user,
+        "build":   buildInfo(),
+    })
+}
+
+// Collects the Go version and the commit and time the binary was built from.
+// Values passed through -ldflags win over the VCS stamp the Go toolchain embeds.
+func buildInfo() map[string]interface{} {
+    info := map[string]interface{}{
+        "go_version": runtime.Version(),
+        "commit":     gitCommit,
+        "build_time": buildTime,
+    }
+    if bi, ok := debug.ReadBuildInfo(); ok {
+        for _, setting := range bi.Settings {
+            switch setting.Key {
+            case "vcs.revision":
+                if gitCommit == "" {
+                    info["commit"] = setting.Value
+                }
+            case "vcs.time":
+                if buildTime == "" {
+                    info["build_time"] = setting.Value
+                }
+            case "vcs.modified":
+                info["modified"] = setting.Value == "true"
+            }
+        }
+    }
+    return info
+}
+
+// Reports the identity behind the current session so clients can restore
+// their auth state after a reload
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := r.Context().Value("user").(string)
+    if !ok {
+        components.LogRequest(r, "User context not found")
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+    role, _ := r.Context().Value("role").(string)
+    expiresAt, _ := r.Context().Value("expires").(time.Time)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "user":       user,
+        "role":       role,
+        "expires_at": expiresAt.UTC().Format(time.RFC3339),
     })
 }
 
+// Returns the role of a user: admin for those listed in ADMIN_USERS, user
+// for everyone else
+func roleFor(name string) string {
+    if adminUsers[name] {
+        return "admin"
+    }
+    return "user"
+}
+
 // Helper function to create a JWT token
-func createToken(username string, expiry time.Duration) (string, error) {
-    token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+func createToken(username string, csrfToken string, sessionID string, expiry time.Duration) (string, error) {
+    return signToken(jwt.MapClaims{
         "username": username,
+        "role":     roleFor(username),
+        "csrf":     csrfToken,
+        "sid":      sessionID,
         "exp":      time.Now().Add(expiry).Unix(),
     })
+}
 
-    tokenString, err := token.SignedString(privateKey)
-    if err != nil {
+// Signs claims with HS256 when JWT_SECRET is configured, RS256 otherwise
+func signToken(claims jwt.MapClaims) (string, error) {
+    if jwtSecret != nil {
+        return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+    }
+    return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+}
+
+// Sets the session cookie carrying the access token
+func setSessionCookie(w http.ResponseWriter, token string) {
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookieName,
+        Value:    token,
+        Path:     "/",
+        MaxAge:   int(tokenExpiry.Seconds()),
+        HttpOnly: true,
+        Secure:   cookieSecure,
+        SameSite: cookieSameSite,
+    })
+}
+
+// Generates a random token, used for CSRF tokens and session IDs
+func randomToken() (string, error) {
+    buf := make([]byte, 32)
+    if _, err := rand.Read(buf); err != nil {
         return "", err
     }
+    return hex.EncodeToString(buf), nil
+}
 
-    return tokenString, nil
+// Sets the CSRF cookie, readable from scripts so it can be echoed in the header
+func setCSRFCookie(w http.ResponseWriter, token string) {
+    http.SetCookie(w, &http.Cookie{
+        Name:     csrfCookieName,
+        Value:    token,
+        Path:     "/",
+        MaxAge:   int(tokenExpiry.Seconds()),
+        Secure:   cookieSecure,
+        SameSite: cookieSameSite,
+    })
 }
 
-// Handles OPTIONS requests for CORS preflight
-func optionsHandler(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-    w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-    w.WriteHeader(http.StatusOK)
+// Reports whether a request method can change server state
+func isStateChanging(method string) bool {
+    switch method {
+    case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+        return true
+    }
+    return false
 }
 
 // Middleware to add security-related headers to responses
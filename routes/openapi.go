@@ -0,0 +1,423 @@
+// routes/openapi.go
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type apiParam struct {
+	Name        string
+	Required    bool
+	Description string
+}
+
+type apiOperation struct {
+	Summary  string
+	Params   []apiParam
+	Body     string // description of the JSON request body, if any
+	Response string // name of the response schema, if any
+	Public   bool   // reachable without authentication
+}
+
+var scopeParam = apiParam{Name: "scope", Description: "user (default) or system; system requires admin and ALLOW_SYSTEM_SCOPE=true"}
+
+func targetParam(description string) apiParam {
+	return apiParam{Name: "target", Required: true, Description: description}
+}
+
+var fileParams = []apiParam{
+	{Name: "filepath", Required: true, Description: "Directory of the file, absolute or relative to the sandbox root"},
+	{Name: "filename", Required: true, Description: "Name of the file"},
+}
+
+// Hand-written descriptions of the registered routes, keyed by method and
+// path. Paths are matched as suffixes of the registered path templates so
+// the mount prefix does not matter. Routes without an entry are still listed.
+var apiDocs = map[string]apiOperation{
+	"GET /ping":         {Summary: "Liveness check", Public: true},
+	"GET /healthz":      {Summary: "Readiness and available system tools", Public: true},
+	"POST /login":       {Summary: "Log in and receive an access token and CSRF token", Body: "{\"username\": \"...\", \"password\": \"...\", \"mode\": \"cookie or token\"}", Public: true},
+	"GET /version":      {Summary: "API version, build info and authenticated user"},
+	"POST /ws-token":    {Summary: "Short-lived token for a WebSocket upgrade"},
+	"GET /whoami":       {Summary: "Username, role and expiry of the current session"},
+	"GET /openapi.json": {Summary: "This document", Public: true},
+	"GET /metrics":      {Summary: "Prometheus metrics", Public: true},
+
+	"GET /system/services": {Summary: "List user services and sockets", Params: []apiParam{
+		scopeParam,
+		{Name: "fields", Description: "Comma-separated Unit fields to return, such as UNIT,ACTIVE"},
+		{Name: "states", Description: "Comma-separated active or sub states to keep, such as failed,activating"},
+	}, Response: "UnitList"},
+	"POST /system/services/start":   {Summary: "Start a user service", Params: []apiParam{targetParam("Name of the service"), scopeParam}, Response: "Transition"},
+	"POST /system/services/stop":    {Summary: "Stop a user service", Params: []apiParam{targetParam("Name of the service"), scopeParam}, Response: "Transition"},
+	"POST /system/services/restart": {Summary: "Restart a user service", Params: []apiParam{targetParam("Name of the service"), scopeParam}, Response: "Message"},
+	"POST /system/services/reload": {Summary: "Reload a service, optionally restarting it when it cannot reload", Params: []apiParam{
+		targetParam("Name of the service"),
+		scopeParam,
+		{Name: "orRestart", Description: "true to restart services that do not support reload"},
+	}},
+	"POST /system/services/restart-failed": {Summary: "Restart all failed units", Params: []apiParam{
+		{Name: "pattern", Description: "Glob pattern restricting the units restarted"},
+		scopeParam,
+	}},
+	"POST /system/services/restart-if-changed": {Summary: "Restart a service only when its unit files changed", Params: []apiParam{
+		targetParam("Name of the service"),
+		scopeParam,
+		{Name: "checksum", Description: "Checksum returned by an earlier call"},
+	}},
+	"POST /system/services/reset-failed": {Summary: "Clear the failed state of a unit, or of all failed units without a target", Params: []apiParam{
+		{Name: "target", Description: "Name of the unit; omit to reset every failed unit"},
+		scopeParam,
+	}, Response: "Message"},
+	"POST /system/services/reset-failed-pattern": {Summary: "Reset failed units matching a glob pattern", Params: []apiParam{scopeParam}, Body: "{\"pattern\": \"myapp-*\"}"},
+	"GET /system/services/output-config":         {Summary: "Read StandardOutput, StandardError and SyslogIdentifier", Params: []apiParam{targetParam("Name of the service"), scopeParam}},
+	"POST /system/services/output-config": {Summary: "Update output configuration through a drop-in, keeping settings left out", Params: []apiParam{targetParam("Name of the service"), scopeParam},
+		Body: "{\"StandardOutput\": \"journal\", \"StandardError\": \"append:/path\", \"SyslogIdentifier\": \"name\"}"},
+	"GET /system/services/exit-info": {Summary: "Last exit status and restart count", Params: []apiParam{targetParam("Name of the service"), scopeParam}, Response: "ExitInfo"},
+	"GET /system/services/logs": {Summary: "Recent journal entries of a service", Params: []apiParam{
+		targetParam("Name of the service"),
+		{Name: "lines", Description: "Number of entries, 1 to 1000"},
+		{Name: "since", Description: "Lower time bound, journalctl syntax"},
+		{Name: "until", Description: "Upper time bound, journalctl syntax"},
+		{Name: "before", Description: "Return the entries preceding this cursor"},
+		{Name: "after", Description: "Return the entries following this cursor"},
+		{Name: "follow", Description: "true to stream new entries as newline-delimited JSON"},
+	}, Response: "LogEntries"},
+	"GET /system/services/logs/export": {Summary: "Download the journal of a service in export format", Params: []apiParam{
+		targetParam("Name of the service"),
+		{Name: "since", Description: "Lower time bound, journalctl syntax"},
+		{Name: "until", Description: "Upper time bound, journalctl syntax"},
+	}},
+	"GET /system/services/unit-state": {Summary: "Unit file and load state", Params: []apiParam{targetParam("Name of the unit"), scopeParam}, Response: "UnitState"},
+	"POST /system/services/unit-state": {Summary: "Move a unit to enabled, disabled, masked or unmasked", Params: []apiParam{
+		targetParam("Name of the unit"),
+		scopeParam,
+		{Name: "state", Required: true, Description: "enabled, disabled, masked or unmasked"},
+	}},
+	"POST /system/services/mask":   {Summary: "Mask a unit so it cannot be started", Params: []apiParam{targetParam("Name of the unit"), scopeParam}},
+	"POST /system/services/unmask": {Summary: "Unmask a unit", Params: []apiParam{targetParam("Name of the unit"), scopeParam}},
+	"GET /system/services/watch": {Summary: "Wait for the ActiveState or SubState of a unit to change; 304 when the timeout passes first", Params: []apiParam{
+		targetParam("Name of the unit"),
+		scopeParam,
+		{Name: "timeout", Description: "Seconds to wait, 1 to 120 (default 30)"},
+		{Name: "active", Description: "ActiveState the client last saw"},
+		{Name: "sub", Description: "SubState the client last saw"},
+	}},
+	"GET /system/services/resources": {Summary: "Memory, CPU, task and IP traffic accounting of a unit; null where not available", Params: []apiParam{targetParam("Name of the unit"), scopeParam}},
+	"POST /system/services/verify": {Summary: "Check unit file content with systemd-analyze verify", Params: []apiParam{
+		{Name: "name", Description: "Unit file name, which sets the unit type (default unit.service)"},
+	}, Body: "Unit file content"},
+	"GET /system/services/environment": {Summary: "Environment variables and environment files of a unit", Params: []apiParam{
+		targetParam("Name of the service"),
+		scopeParam,
+		{Name: "redact", Description: "true to hide values of variables that look like secrets"},
+	}},
+	"GET /system/services/cat": {Summary: "Unit file and drop-in contents of a unit", Params: []apiParam{
+		targetParam("Name of the unit"),
+		scopeParam,
+	}},
+	"GET /system/services/dependencies": {Summary: "Dependency tree of a unit", Params: []apiParam{
+		targetParam("Name of the unit"),
+		scopeParam,
+		{Name: "reverse", Description: "true to list units depending on the target"},
+		{Name: "depth", Description: "Maximum tree depth, 1 to 10"},
+	}},
+	"GET /system/services/transitioning": {Summary: "Units currently activating or deactivating", Params: []apiParam{scopeParam}},
+	"GET /system/services/pressure":      {Summary: "Pressure stall information of a service cgroup", Params: []apiParam{targetParam("Name of the service"), scopeParam}},
+	"GET /system/pressure":               {Summary: "System pressure stall information"},
+	"GET /system/summary":                {Summary: "Manager state and unit counts", Params: []apiParam{scopeParam}},
+	"GET /system/logs/errors/stream":     {Summary: "Server-Sent Events stream of error-level journal entries"},
+	"POST /system/write": {Summary: "Write a file", Params: append(append([]apiParam{}, fileParams...),
+		apiParam{Name: "filecontent", Required: true, Description: "Content to write"},
+		apiParam{Name: "append", Description: "true to append instead of overwrite"},
+		apiParam{Name: "validate", Description: "json or yaml; refuse invalid content"},
+		apiParam{Name: "createDirs", Description: "true to create missing parent directories"},
+	), Response: "Message"},
+	"GET /system/read": {Summary: "Read a file or a line or byte range of it", Params: append(append([]apiParam{}, fileParams...),
+		apiParam{Name: "startLine", Description: "First line to return, 1-indexed"},
+		apiParam{Name: "endLine", Description: "Last line to return, inclusive"},
+		apiParam{Name: "offset", Description: "First byte to return"},
+		apiParam{Name: "length", Description: "Number of bytes to return"},
+		apiParam{Name: "raw", Description: "true to receive the file itself with its detected Content-Type"},
+		apiParam{Name: "download", Description: "true to receive the file as an attachment"},
+	)},
+	"POST /system/read-batch": {Summary: "Read several files", Body: "{\"files\": [{\"filepath\": \"...\", \"filename\": \"...\"}]}"},
+	"GET /system/permissions": {Summary: "Mode and ownership of a file", Params: fileParams},
+	"POST /system/chmod": {Summary: "Change the mode of a file", Params: append(append([]apiParam{}, fileParams...),
+		apiParam{Name: "mode", Required: true, Description: "Octal mode such as 0644"},
+	)},
+	"POST /system/chown": {Summary: "Change the owner of a file (admin only, needs ALLOW_CHOWN=true)", Params: append(append([]apiParam{}, fileParams...),
+		apiParam{Name: "uid", Description: "New owner user ID, -1 to keep"},
+		apiParam{Name: "gid", Description: "New owner group ID, -1 to keep"},
+	)},
+	"GET /system/config/get": {Summary: "Read a key, or every key, of the CONFIG_FILE env file", Params: []apiParam{
+		{Name: "key", Description: "Key to read; omit to list all keys"},
+		{Name: "redact", Description: "true to hide values of keys that look like secrets when listing"},
+	}},
+	"POST /system/config/set": {Summary: "Set a key in the CONFIG_FILE env file, keeping comments and order", Body: "{\"key\": \"PORT\", \"value\": \"8080\"}", Response: "Message"},
+	"GET /system/du": {Summary: "Total size and file count of a path", Params: []apiParam{
+		{Name: "filepath", Required: true, Description: "Directory or file to measure"},
+		{Name: "maxDepth", Description: "Directory levels to descend, 0 to 64 (default 32)"},
+	}},
+	"POST /system/sign-download": {Summary: "Signed, expiring link to download a file without the session", Params: append(append([]apiParam{}, fileParams...),
+		apiParam{Name: "expires_in", Description: "Seconds the link stays valid, 1 to 86400 (default 900)"},
+	)},
+	"GET /system/download": {Summary: "Download a file through a link from sign-download", Public: true, Params: []apiParam{
+		{Name: "path", Required: true, Description: "Set by sign-download"},
+		{Name: "user", Required: true, Description: "Set by sign-download"},
+		{Name: "expires", Required: true, Description: "Set by sign-download"},
+		{Name: "signature", Required: true, Description: "Set by sign-download"},
+	}},
+	"GET /system/tail": {Summary: "Last lines of a file, optionally following it as it grows", Params: append(append([]apiParam{}, fileParams...),
+		apiParam{Name: "lines", Description: "Number of lines, 1 to 1000 (default 100)"},
+		apiParam{Name: "follow", Description: "true to keep streaming appended lines as text/plain"},
+	)},
+	"GET /system/archive": {Summary: "Download a directory as a tar.gz archive (at most 1 GiB of files)", Params: []apiParam{
+		{Name: "filepath", Required: true, Description: "Directory to archive"},
+	}},
+	"POST /system/extract": {Summary: "Extract an uploaded tar.gz archive (multipart field archive) into a directory", Params: []apiParam{
+		{Name: "filepath", Required: true, Description: "Directory to extract into, created when missing"},
+	}},
+	"POST /system/power": {Summary: "Log out, reboot or power off the host (admin only, needs ALLOW_POWER=true)", Params: []apiParam{
+		{Name: "action", Required: true, Description: "logout, reboot or poweroff"},
+		{Name: "confirm", Description: "Token returned by a first request without it; the action runs only when it is sent"},
+	}},
+	"GET /system/analyze/blame": {Summary: "Initialization time of each user unit, slowest first", Params: []apiParam{
+		{Name: "limit", Description: "Maximum number of units, 1 to 1000 (default 100)"},
+	}},
+	"GET /system/analyze/time": {Summary: "Startup time of the user manager by phase"},
+	"POST /system/mkdir": {Summary: "Create a directory", Params: []apiParam{
+		{Name: "filepath", Required: true, Description: "Directory to create"},
+		{Name: "parents", Description: "true to create missing parent directories"},
+	}, Response: "Message"},
+	"POST /system/move": {Summary: "Move or rename a file", Params: []apiParam{
+		{Name: "overwrite", Description: "true to replace an existing destination"},
+	}, Body: "{\"from\": \"...\", \"to\": \"...\"}"},
+	"GET /system/at/output": {Summary: "Captured output of a job scheduled with the at backend", Params: []apiParam{
+		{Name: "job", Required: true, Description: "Job number returned when scheduling"},
+	}},
+	"POST /system/at": {Summary: "Schedule a command with at or a transient systemd timer", Params: []apiParam{
+		{Name: "time", Required: true, Description: "Time in at syntax; for systemd-run a calendar expression, or +duration relative to now"},
+		{Name: "command", Required: true, Description: "Command to run"},
+		{Name: "backend", Description: "at (default) or systemd-run"},
+	}},
+
+	"GET /docker/running":  {Summary: "List running containers"},
+	"GET /docker/image/ls": {Summary: "List images"},
+	"POST /docker/start":   {Summary: "Start a container", Params: []apiParam{targetParam("Container name or ID")}},
+	"POST /docker/stop":    {Summary: "Stop a container", Params: []apiParam{targetParam("Container name or ID")}},
+	"POST /docker/restart": {Summary: "Restart a container", Params: []apiParam{targetParam("Container name or ID")}},
+	"DELETE /docker/image/rm": {Summary: "Remove an image", Params: []apiParam{
+		{Name: "targetid", Required: true, Description: "Image ID"},
+		{Name: "toforce", Description: "true to force removal"},
+	}},
+	"GET /nest/resources": {Summary: "Disk and memory usage reported by nest"},
+}
+
+var apiSchemas = map[string]interface{}{
+	"Error": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error":      map[string]string{"type": "string"},
+			"detail":     map[string]string{"type": "string"},
+			"request_id": map[string]string{"type": "string"},
+		},
+	},
+	"Message": map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"message": map[string]string{"type": "string"}},
+	},
+	"Transition": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message": map[string]string{"type": "string"},
+			"noop":    map[string]string{"type": "boolean"},
+			"before":  map[string]interface{}{"type": "object", "additionalProperties": map[string]string{"type": "string"}},
+			"after":   map[string]interface{}{"type": "object", "additionalProperties": map[string]string{"type": "string"}},
+		},
+	},
+	"Unit": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"UNIT":        map[string]string{"type": "string"},
+			"LOAD":        map[string]string{"type": "string"},
+			"ACTIVE":      map[string]string{"type": "string"},
+			"SUB":         map[string]string{"type": "string"},
+			"DESCRIPTION": map[string]string{"type": "string"},
+		},
+	},
+	"UnitList": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"services": map[string]interface{}{"type": "array", "items": map[string]string{"$ref": "#/components/schemas/Unit"}},
+			"sockets":  map[string]interface{}{"type": "array", "items": map[string]string{"$ref": "#/components/schemas/Unit"}},
+			"cached":   map[string]string{"type": "boolean"},
+			"matched":  map[string]string{"type": "integer"},
+			"warning":  map[string]string{"type": "string"},
+		},
+	},
+	"LogEntries": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"entries": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"cursor":    map[string]string{"type": "string"},
+						"timestamp": map[string]string{"type": "string", "format": "date-time"},
+						"priority":  map[string]string{"type": "integer"},
+						"unit":      map[string]string{"type": "string"},
+						"message":   map[string]string{"type": "string"},
+					},
+				},
+			},
+			"first_cursor": map[string]string{"type": "string"},
+			"last_cursor":  map[string]string{"type": "string"},
+		},
+	},
+	"ExitInfo": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"unit":     map[string]string{"type": "string"},
+			"result":   map[string]string{"type": "string"},
+			"code":     map[string]string{"type": "string"},
+			"status":   map[string]string{"type": "integer"},
+			"signal":   map[string]interface{}{"type": "string", "nullable": true},
+			"restarts": map[string]string{"type": "integer"},
+		},
+	},
+	"UnitState": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"unit":          map[string]string{"type": "string"},
+			"UnitFileState": map[string]string{"type": "string"},
+			"LoadState":     map[string]string{"type": "string"},
+		},
+	},
+}
+
+// Finds the documentation entry whose path is the longest suffix of the route
+func lookupAPIDoc(method, path string) (apiOperation, bool) {
+	best := ""
+	var found apiOperation
+	for key, doc := range apiDocs {
+		docMethod, docPath, _ := strings.Cut(key, " ")
+		if docMethod != method || !strings.HasSuffix(path, docPath) || len(docPath) <= len(best) {
+			continue
+		}
+		best = docPath
+		found = doc
+	}
+	return found, best != ""
+}
+
+func buildOperation(method, path string) map[string]interface{} {
+	doc, ok := lookupAPIDoc(method, path)
+	if !ok {
+		doc = apiOperation{Summary: method + " " + path}
+	}
+
+	operation := map[string]interface{}{"summary": doc.Summary}
+	if doc.Public {
+		operation["security"] = []interface{}{}
+	}
+
+	if len(doc.Params) > 0 {
+		params := []interface{}{}
+		for _, param := range doc.Params {
+			params = append(params, map[string]interface{}{
+				"name":        param.Name,
+				"in":          "query",
+				"required":    param.Required,
+				"description": param.Description,
+				"schema":      map[string]string{"type": "string"},
+			})
+		}
+		operation["parameters"] = params
+	}
+
+	if doc.Body != "" {
+		operation["requestBody"] = map[string]interface{}{
+			"required":    true,
+			"description": doc.Body,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]string{"type": "object"}},
+			},
+		}
+	}
+
+	success := map[string]interface{}{"description": "Success"}
+	if doc.Response != "" {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/" + doc.Response}},
+		}
+	}
+	errorResponse := map[string]interface{}{"description": "Error"}
+	operation["responses"] = map[string]interface{}{
+		"200":     success,
+		"default": errorResponse,
+	}
+	return operation
+}
+
+// Builds the OpenAPI 3 document from the routes registered on router
+func buildOpenAPI(router *mux.Router, version string) map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			if paths[path] == nil {
+				paths[path] = map[string]interface{}{}
+			}
+			paths[path][strings.ToLower(method)] = buildOperation(method, path)
+		}
+		return nil
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "napi",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": apiSchemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookieAuth": map[string]string{"type": "apiKey", "in": "cookie", "name": "napi_session"},
+			},
+		},
+		"security": []interface{}{
+			map[string][]string{"bearerAuth": {}},
+			map[string][]string{"cookieAuth": {}},
+		},
+	}
+}
+
+// OpenAPIHandler serves an OpenAPI 3 document describing the routes
+// registered on router at the time of the request
+func OpenAPIHandler(router *mux.Router, version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buildOpenAPI(router, version))
+	}
+}
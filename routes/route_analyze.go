@@ -0,0 +1,172 @@
+// routes/route_analyze.go
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBlameUnits = 100
+	maxBlameUnits     = 1000
+)
+
+var (
+	timespanPartRe = regexp.MustCompile(`^(\d+(?:\.\d+)?)(y|month|w|d|h|min|s|ms|us|µs)$`)
+	// "2.5s (kernel)" in the systemd-analyze time summary
+	startupPhaseRe  = regexp.MustCompile(`([0-9][^()=+]*?) \(([a-z ]+)\)`)
+	targetReachedRe = regexp.MustCompile(`^(\S+) reached after (.+?) in userspace`)
+)
+
+var timespanUnits = map[string]float64{
+	"y": 31557600, "month": 2629800, "w": 604800, "d": 86400, "h": 3600,
+	"min": 60, "s": 1, "ms": 1e-3, "us": 1e-6, "µs": 1e-6,
+}
+
+// Parses a systemd time span such as "1min 2.345s" or "87ms" into seconds
+func parseTimespan(value string) (float64, bool) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	total := 0.0
+	for _, field := range fields {
+		match := timespanPartRe.FindStringSubmatch(field)
+		if match == nil {
+			return 0, false
+		}
+		number, _ := strconv.ParseFloat(match[1], 64)
+		total += number * timespanUnits[match[2]]
+	}
+	return total, true
+}
+
+type BlameEntry struct {
+	Unit    string  `json:"unit"`
+	Time    string  `json:"time"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Parses systemd-analyze blame output, one "<time span> <unit>" per line,
+// already sorted slowest first
+func parseBlame(output string) []BlameEntry {
+	entries := []BlameEntry{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		span := strings.Join(fields[:len(fields)-1], " ")
+		seconds, ok := parseTimespan(span)
+		if !ok {
+			continue
+		}
+		entries = append(entries, BlameEntry{Unit: fields[len(fields)-1], Time: span, Seconds: seconds})
+	}
+	return entries
+}
+
+type StartupPhase struct {
+	Phase   string  `json:"phase"`
+	Time    string  `json:"time"`
+	Seconds float64 `json:"seconds"`
+}
+
+type StartupTime struct {
+	Phases        []StartupPhase `json:"phases"`
+	Total         string         `json:"total"`
+	TotalSeconds  float64        `json:"total_seconds"`
+	Target        string         `json:"target,omitempty"`
+	TargetReached string         `json:"target_reached,omitempty"`
+}
+
+// Parses the summary printed by systemd-analyze time, e.g.
+// "Startup finished in 2.5s (kernel) + 10s (userspace) = 12.5s"
+func parseStartupTime(output string) (StartupTime, bool) {
+	result := StartupTime{Phases: []StartupPhase{}}
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Startup finished in ") {
+			found = true
+			phases, total, _ := strings.Cut(strings.TrimPrefix(line, "Startup finished in "), "=")
+			for _, match := range startupPhaseRe.FindAllStringSubmatch(phases, -1) {
+				seconds, _ := parseTimespan(match[1])
+				result.Phases = append(result.Phases, StartupPhase{Phase: match[2], Time: strings.TrimSpace(match[1]), Seconds: seconds})
+			}
+			result.Total = strings.TrimSpace(total)
+			result.TotalSeconds, _ = parseTimespan(result.Total)
+		} else if match := targetReachedRe.FindStringSubmatch(line); match != nil {
+			result.Target = match[1]
+			result.TargetReached = match[2]
+		}
+	}
+	return result, found
+}
+
+// Runs systemd-analyze --user, writing the error response itself on failure
+func runAnalyze(w http.ResponseWriter, r *http.Request, args ...string) (string, bool) {
+	out, detail, err := runCommand(r.Context(), "systemd-analyze", append([]string{"--user"}, args...)...)
+	if err == errCommandTimeout {
+		writeTimeoutError(w, "Timed out running systemd-analyze "+args[0])
+		return "", false
+	}
+	if err != nil {
+		// Reported while the user manager is still starting up
+		if strings.Contains(detail, "not yet finished") {
+			writeJSONError(w, http.StatusConflict, "Startup has not finished yet", detail)
+			return "", false
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Error running systemd-analyze "+args[0], detail)
+		return "", false
+	}
+	return out, true
+}
+
+func AnalyzeBlame(w http.ResponseWriter, r *http.Request) {
+	limit := defaultBlameUnits
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxBlameUnits {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxBlameUnits), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	out, ok := runAnalyze(w, r, "blame", "--no-pager")
+	if !ok {
+		return
+	}
+	entries := parseBlame(out)
+	total := len(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"units":     entries,
+		"total":     total,
+		"truncated": total > len(entries),
+	})
+}
+
+func AnalyzeTime(w http.ResponseWriter, r *http.Request) {
+	out, ok := runAnalyze(w, r, "time", "--no-pager")
+	if !ok {
+		return
+	}
+	result, found := parseStartupTime(out)
+	if !found {
+		writeJSONError(w, http.StatusInternalServerError, "Unexpected systemd-analyze time output", strings.TrimSpace(out))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,401 @@
+// routes/route_archive.go
+
+package routes
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"napi/components"
+)
+
+const (
+	// Total size of the files an archive may contain, before compression
+	maxArchiveBytes = 1 << 30
+	// Time building and sending, or receiving and extracting, a single
+	// archive may take
+	archiveTimeout = 10 * time.Minute
+	// Size of a single file extracted from an uploaded archive
+	maxExtractFileBytes = 256 << 20
+	// Entries an uploaded archive may hold, directories included
+	maxExtractEntries = 10000
+	// Size of an archive upload, which is exempt from MAX_REQUEST_BYTES; a
+	// compressed archive within maxArchiveBytes fits
+	maxExtractUploadBytes = maxArchiveBytes + 1<<20
+)
+
+var errArchiveTooLarge = errors.New("archive exceeds the size limit")
+
+// ExtractResult lists what an upload put under Path, relative to it. Skipped
+// holds entries that were left out: links leading outside the target and
+// types other than files, directories and symlinks.
+type ExtractResult struct {
+	Path    string   `json:"path"`
+	Files   []string `json:"files"`
+	Skipped []string `json:"skipped"`
+}
+
+// An archive entry refused by extractArchive, answered with status
+type extractError struct {
+	status  int
+	message string
+}
+
+func (e *extractError) Error() string { return e.message }
+
+// Name of the top-level directory inside an archive of dir
+func archiveName(dir string) string {
+	name := filepath.Base(dir)
+	if name == string(filepath.Separator) {
+		return "root"
+	}
+	return name
+}
+
+// Writes dir to tw with entry names prefixed by the directory's own name.
+// Symlinks are stored as links, not followed, and only when their target
+// resolves inside root; devices, sockets and pipes are skipped.
+func writeArchive(ctx context.Context, tw *tar.Writer, root, dir string) error {
+	prefix := archiveName(dir)
+	remaining := int64(maxArchiveBytes)
+
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Unreadable entries are left out rather than failing the archive
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		link := ""
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil || !withinRoot(root, target) {
+				return nil
+			}
+			if link, err = os.Readlink(path); err != nil {
+				return nil
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if !info.Mode().IsRegular() {
+			return tw.WriteHeader(header)
+		}
+		if header.Size > remaining {
+			return errArchiveTooLarge
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer file.Close()
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		// The header's size is fixed, so a file growing while it is read is cut
+		// to the size it had when stat'ed
+		written, err := io.Copy(tw, io.LimitReader(file, header.Size))
+		if err != nil {
+			return err
+		}
+		remaining -= written
+		return nil
+	})
+}
+
+func ArchiveDirectory(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("filepath")
+	if dir == "" {
+		http.Error(w, "Filepath is required", http.StatusBadRequest)
+		return
+	}
+	root, err := sandboxRoot(r)
+	if err != nil {
+		http.Error(w, "Error resolving path", http.StatusInternalServerError)
+		return
+	}
+	fullPath, err := resolveSandboxPath(r, dir, "")
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Path "+dir+" does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading path "+dir, http.StatusInternalServerError)
+		return
+	}
+	if !info.IsDir() {
+		http.Error(w, "Path "+dir+" is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), archiveTimeout)
+	defer cancel()
+
+	// Checked up front so an oversized directory gets an error status rather
+	// than an archive that breaks off part way
+	usage := diskUsage(ctx, fullPath, maxUsageDepth)
+	if usage.Bytes > maxArchiveBytes {
+		http.Error(w, "Directory exceeds the archive limit of "+strconv.Itoa(maxArchiveBytes)+" bytes", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": archiveName(fullPath) + ".tar.gz",
+	}))
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = writeArchive(ctx, tw, root, fullPath)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		// The status is already sent, so drop the connection to keep the
+		// client from taking a cut off archive for a complete one
+		log.Printf("Archive of %s aborted: %v", fullPath, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// Writes the content of a regular entry to dest through a temporary file,
+// failing once more than limit bytes have been read
+func extractFile(dest string, content io.Reader, mode os.FileMode, limit int64) (int64, error) {
+	temp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	tempPath := temp.Name()
+	defer os.Remove(tempPath)
+
+	written, err := io.Copy(temp, io.LimitReader(content, limit+1))
+	if err == nil && written > limit {
+		err = errArchiveTooLarge
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+	if err := os.Chmod(tempPath, mode); err != nil {
+		return written, err
+	}
+	return written, os.Rename(tempPath, dest)
+}
+
+// Extracts the tar stream tr into target, which must already exist with its
+// symlinks resolved. Entries whose names lead outside target, or whose parent
+// directory does through a symlink, fail the extraction; entries before them
+// stay in place.
+func extractArchive(ctx context.Context, tr *tar.Reader, target string, result *ExtractResult) error {
+	remaining := int64(maxArchiveBytes)
+	for entries := 0; ; entries++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entries >= maxExtractEntries {
+			return &extractError{http.StatusRequestEntityTooLarge, "Archive has more than " + strconv.Itoa(maxExtractEntries) + " entries"}
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		dest := filepath.Join(target, name)
+		if filepath.IsAbs(name) || !withinRoot(target, dest) {
+			return &extractError{http.StatusBadRequest, "Entry " + header.Name + " is outside the target directory"}
+		}
+		if dest == target {
+			continue
+		}
+		// Resolved before creating anything so that a symlink already in the
+		// target cannot lead the new directories outside of it
+		parent, err := resolveExisting(filepath.Dir(dest))
+		if err != nil {
+			return err
+		}
+		if !withinRoot(target, parent) {
+			return &extractError{http.StatusBadRequest, "Entry " + header.Name + " is outside the target directory"}
+		}
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+		dest = filepath.Join(parent, filepath.Base(dest))
+		rel, _ := filepath.Rel(target, dest)
+		rel = filepath.ToSlash(rel)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if header.Size > maxExtractFileBytes {
+				return &extractError{http.StatusRequestEntityTooLarge, "Entry " + header.Name + " exceeds the file limit of " + strconv.Itoa(maxExtractFileBytes) + " bytes"}
+			}
+			if header.Size > remaining {
+				return &extractError{http.StatusRequestEntityTooLarge, "Archive exceeds the limit of " + strconv.Itoa(maxArchiveBytes) + " bytes"}
+			}
+			written, err := extractFile(dest, tr, header.FileInfo().Mode().Perm(), header.Size)
+			if err != nil {
+				return err
+			}
+			remaining -= written
+		case tar.TypeSymlink:
+			linkTarget := header.Linkname
+			if !filepath.IsAbs(linkTarget) {
+				linkTarget = filepath.Join(filepath.Dir(dest), linkTarget)
+			}
+			if !withinRoot(target, filepath.Clean(linkTarget)) {
+				result.Skipped = append(result.Skipped, rel)
+				continue
+			}
+			os.Remove(dest)
+			if err := os.Symlink(header.Linkname, dest); err != nil {
+				return err
+			}
+		default:
+			result.Skipped = append(result.Skipped, rel)
+			continue
+		}
+		result.Files = append(result.Files, rel)
+	}
+}
+
+func ExtractArchive(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("filepath")
+	if dir == "" {
+		http.Error(w, "Filepath is required", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength > maxExtractUploadBytes {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxExtractUploadBytes)
+	fullPath, err := resolveSandboxPath(r, dir, "")
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data upload", http.StatusBadRequest)
+		return
+	}
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err != nil {
+			if components.IsBodyTooLarge(err) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Missing archive field in upload", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "archive" {
+			break
+		}
+	}
+	defer part.Close()
+
+	if err := os.MkdirAll(fullPath, 0755); err != nil {
+		http.Error(w, "Error creating directory "+dir, http.StatusInternalServerError)
+		return
+	}
+	if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+		http.Error(w, "Path "+dir+" is not a directory", http.StatusBadRequest)
+		return
+	}
+	gz, err := gzip.NewReader(part)
+	if err != nil {
+		http.Error(w, "Archive is not gzip-compressed", http.StatusBadRequest)
+		return
+	}
+	defer gz.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), archiveTimeout)
+	defer cancel()
+	result := ExtractResult{Path: dir, Files: []string{}, Skipped: []string{}}
+	err = extractArchive(ctx, tar.NewReader(gz), fullPath, &result)
+	var refused *extractError
+	switch {
+	case err == nil:
+	case errors.As(err, &refused):
+		http.Error(w, refused.message, refused.status)
+		return
+	case components.IsBodyTooLarge(err):
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, errArchiveTooLarge):
+		http.Error(w, "Archive entry is larger than its header states", http.StatusBadRequest)
+		return
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader), errors.Is(err, tar.ErrHeader), errors.Is(err, io.ErrUnexpectedEOF):
+		http.Error(w, "Invalid archive: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "Extracting archive took too long", http.StatusGatewayTimeout)
+		return
+	default:
+		log.Printf("Extracting into %s failed: %v", fullPath, err)
+		http.Error(w, "Error extracting archive", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,229 @@
+// routes/route_archive_test.go
+
+package routes
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// An entry of a test archive; content is only written for regular files
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+	size     int64
+}
+
+func buildTar(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.linkname, Mode: 0644}
+		if entry.typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.content))
+			if entry.size > 0 {
+				header.Size = entry.size
+			}
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if entry.size == 0 {
+			if _, err := tw.Write([]byte(entry.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// An entry whose header claims more than was written leaves the archive
+	// short, which is what the size checks have to catch
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name        string
+		entries     []archiveEntry
+		wantStatus  int
+		wantFiles   []string
+		wantSkipped []string
+	}{
+		{
+			name: "files and directories",
+			entries: []archiveEntry{
+				{name: "conf/", typeflag: tar.TypeDir},
+				{name: "conf/app.env", typeflag: tar.TypeReg, content: "A=1\n"},
+				{name: "./top.txt", typeflag: tar.TypeReg, content: "top"},
+			},
+			wantFiles: []string{"conf", "conf/app.env", "top.txt"},
+		},
+		{
+			name: "links inside kept, outside skipped",
+			entries: []archiveEntry{
+				{name: "real.txt", typeflag: tar.TypeReg, content: "data"},
+				{name: "good", typeflag: tar.TypeSymlink, linkname: "real.txt"},
+				{name: "bad", typeflag: tar.TypeSymlink, linkname: "../../etc/passwd"},
+				{name: "absolute", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+				{name: "fifo", typeflag: tar.TypeFifo},
+			},
+			wantFiles:   []string{"real.txt", "good"},
+			wantSkipped: []string{"bad", "absolute", "fifo"},
+		},
+		{
+			name:       "parent directory",
+			entries:    []archiveEntry{{name: "../evil.txt", typeflag: tar.TypeReg, content: "x"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "parent inside the name",
+			entries:    []archiveEntry{{name: "conf/../../evil.txt", typeflag: tar.TypeReg, content: "x"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "absolute name",
+			entries:    []archiveEntry{{name: "/tmp/evil.txt", typeflag: tar.TypeReg, content: "x"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			// The link out of the target is not created, so the entry
+			// written through it lands in a plain directory instead
+			name: "write through a skipped link",
+			entries: []archiveEntry{
+				{name: "escape", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "escape/evil.txt", typeflag: tar.TypeReg, content: "x"},
+			},
+			wantFiles:   []string{"escape/evil.txt"},
+			wantSkipped: []string{"escape"},
+		},
+		{
+			name:       "file above the file limit",
+			entries:    []archiveEntry{{name: "huge.bin", typeflag: tar.TypeReg, size: maxExtractFileBytes + 1}},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			target := filepath.Join(parent, "target")
+			if err := os.Mkdir(target, 0755); err != nil {
+				t.Fatal(err)
+			}
+			target, _ = filepath.EvalSymlinks(target)
+
+			result := ExtractResult{Files: []string{}, Skipped: []string{}}
+			err := extractArchive(context.Background(), tar.NewReader(bytes.NewReader(buildTar(t, tt.entries))), target, &result)
+			if tt.wantStatus != 0 {
+				var refused *extractError
+				if !errors.As(err, &refused) || refused.status != tt.wantStatus {
+					t.Fatalf("extractArchive() = %v, want status %d", err, tt.wantStatus)
+				}
+				if _, err := os.Stat(filepath.Join(parent, "evil.txt")); err == nil {
+					t.Errorf("entry written outside the target")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(result.Files, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Errorf("files = %q, want %q", result.Files, tt.wantFiles)
+			}
+			if strings.Join(result.Skipped, ",") != strings.Join(tt.wantSkipped, ",") {
+				t.Errorf("skipped = %q, want %q", result.Skipped, tt.wantSkipped)
+			}
+			if _, err := os.Stat(filepath.Join(parent, "evil.txt")); err == nil {
+				t.Errorf("entry written outside the target")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveExistingLink(t *testing.T) {
+	parent := t.TempDir()
+	target := filepath.Join(parent, "target")
+	outside := filepath.Join(parent, "outside")
+	for _, dir := range []string{target, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(target, "link")); err != nil {
+		t.Fatal(err)
+	}
+	target, _ = filepath.EvalSymlinks(target)
+
+	archive := buildTar(t, []archiveEntry{{name: "link/new/evil.txt", typeflag: tar.TypeReg, content: "x"}})
+	err := extractArchive(context.Background(), tar.NewReader(bytes.NewReader(archive)), target, &ExtractResult{})
+	var refused *extractError
+	if !errors.As(err, &refused) || refused.status != http.StatusBadRequest {
+		t.Fatalf("extractArchive() = %v, want a refused entry", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); err == nil {
+		t.Errorf("directory created outside the target through an existing link")
+	}
+}
+
+func TestExtractArchiveUpload(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    []archiveEntry
+		wantStatus int
+	}{
+		{"extracted", []archiveEntry{{name: "app.env", typeflag: tar.TypeReg, content: "A=1\n"}}, http.StatusOK},
+		{"zip-slip", []archiveEntry{{name: "../evil.txt", typeflag: tar.TypeReg, content: "x"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useSandbox(t)
+
+			var compressed bytes.Buffer
+			gz := gzip.NewWriter(&compressed)
+			gz.Write(buildTar(t, tt.entries))
+			gz.Close()
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			part, err := form.CreateFormFile("archive", "config.tar.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write(compressed.Bytes())
+			form.Close()
+
+			w := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", "/system/extract?filepath=restore", &body)
+			request.Header.Set("Content-Type", form.FormDataContentType())
+			ExtractArchive(w, request)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var result ExtractResult
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(root, "restore", "app.env"))
+			if err != nil || string(content) != "A=1\n" || strings.Join(result.Files, ",") != "app.env" {
+				t.Errorf("extracted %q (%v), result %+v", content, err, result)
+			}
+		})
+	}
+}
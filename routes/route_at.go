@@ -0,0 +1,419 @@
+// routes/route_at.go
+
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bytes of a job's output returned, taken from its end
+const maxAtOutputBytes = 1 << 20
+
+// Settings of scheduled tasks, read by LoadSettings: the directory job output
+// is written to (AT_OUTPUT_DIR), how long it is kept (AT_OUTPUT_RETENTION) and
+// how many days ahead a task may be scheduled (MAX_SCHEDULE_DAYS, 0 removes
+// the limit)
+var (
+	atOutputDirSetting string
+	atOutputRetention  = 7 * 24 * time.Hour
+	maxScheduleDays    = 365
+)
+
+// The job number and time at reports on stderr, as in
+// "job 12 at Thu Jul  4 12:00:00 2024"
+var (
+	atJobRe     = regexp.MustCompile(`(?m)^job (\d+) `)
+	atJobTimeRe = regexp.MustCompile(`(?m)^job \d+ at (.+)$`)
+)
+
+var (
+	// HH:MM, HHMM or H with am or pm
+	atClockRe     = regexp.MustCompile(`^(\d{1,2}):?(\d{2})?(am|pm)?$`)
+	atIncrementRe = regexp.MustCompile(`^\+\s*(\d+)\s*(minute|hour|day|week|month|year)s?$`)
+)
+
+var atJobNumberRe = regexp.MustCompile(`^\d{1,10}$`)
+
+// Directory the output of at jobs is written to, AT_OUTPUT_DIR or napi/at-output
+// in the user's cache directory
+func atOutputDir() (string, error) {
+	if atOutputDirSetting != "" {
+		return filepath.Abs(atOutputDirSetting)
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "napi", "at-output"), nil
+}
+
+// Quotes value as a single word for sh
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// Job numbers still queued or running according to atq, whose lines start
+// with the job number
+func queuedAtJobs(ctx context.Context) (map[string]bool, error) {
+	out, _, err := runCommand(ctx, "atq")
+	if err != nil {
+		return nil, err
+	}
+	return parseAtq(out), nil
+}
+
+func parseAtq(output string) map[string]bool {
+	jobs := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			jobs[fields[0]] = true
+		}
+	}
+	return jobs
+}
+
+// Removes output files last written before the retention period, which for
+// a finished job is measured from when it stopped writing. A job link goes
+// with its output file; one whose file does not exist yet is kept while the
+// job is still in atq, however far ahead it was scheduled, and dropped once
+// the job has left the queue without running.
+func sweepAtOutput(ctx context.Context, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-atOutputRetention)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".out") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		os.Remove(filepath.Join(dir, entry.Name()))
+	}
+
+	var queued map[string]bool
+	for _, entry := range entries {
+		job := strings.TrimSuffix(entry.Name(), ".job")
+		if job == entry.Name() {
+			continue
+		}
+		linkPath := filepath.Join(dir, entry.Name())
+		if _, err := os.Stat(linkPath); err == nil {
+			continue
+		}
+		if queued == nil {
+			// Without atq nothing can be told about pending jobs, so their
+			// links are left alone
+			if queued, err = queuedAtJobs(ctx); err != nil {
+				return
+			}
+		}
+		if !queued[job] {
+			os.Remove(linkPath)
+		}
+	}
+}
+
+// Prepares the output file of a new at job, returning the script to hand to
+// at, which runs command with its output redirected there, and the file's name
+func wrapAtCommand(dir, command string) (string, string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	name := hex.EncodeToString(buf) + ".out"
+	script := "{\n" + command + "\n} > " + shellQuote(filepath.Join(dir, name)) + " 2>&1"
+	return script, name, nil
+}
+
+// Links the job number at assigned to the job's output file
+func linkAtOutput(dir, stderr, name string) (string, bool) {
+	match := atJobRe.FindStringSubmatch(stderr)
+	if match == nil {
+		return "", false
+	}
+	if err := os.Symlink(name, filepath.Join(dir, match[1]+".job")); err != nil {
+		log.Printf("Error recording output of at job %s: %v", match[1], err)
+		return match[1], false
+	}
+	return match[1], true
+}
+
+func AtJobOutput(w http.ResponseWriter, r *http.Request) {
+	job := r.URL.Query().Get("job")
+	if job == "" {
+		http.Error(w, "Job number is required", http.StatusBadRequest)
+		return
+	}
+	if !atJobNumberRe.MatchString(job) {
+		http.Error(w, "Invalid job number", http.StatusBadRequest)
+		return
+	}
+	dir, err := atOutputDir()
+	if err != nil {
+		http.Error(w, "Error locating job output", http.StatusInternalServerError)
+		return
+	}
+	sweepAtOutput(r.Context(), dir)
+
+	linkPath := filepath.Join(dir, job+".job")
+	if _, err := os.Lstat(linkPath); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "No output recorded for job "+job, http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading output of job "+job, http.StatusInternalServerError)
+		return
+	}
+
+	// The file appears once the job starts running
+	response := map[string]interface{}{
+		"job":       job,
+		"started":   false,
+		"output":    "",
+		"truncated": false,
+	}
+	file, err := os.Open(linkPath)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, "Error reading output of job "+job, http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, "Error reading output of job "+job, http.StatusInternalServerError)
+			return
+		}
+		offset := int64(0)
+		if info.Size() > maxAtOutputBytes {
+			offset = info.Size() - maxAtOutputBytes
+		}
+		content, err := io.ReadAll(io.NewSectionReader(file, offset, info.Size()-offset))
+		if err != nil {
+			http.Error(w, "Error reading output of job "+job, http.StatusInternalServerError)
+			return
+		}
+		response["started"] = true
+		response["output"] = string(content)
+		response["truncated"] = offset > 0
+		response["modified"] = info.ModTime().UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// How far ahead a task may be scheduled, 0 for no limit
+func maxScheduleWindow() time.Duration {
+	return time.Duration(maxScheduleDays) * 24 * time.Hour
+}
+
+// Describes why when is outside the schedule window, or returns ""
+func scheduleWindowProblem(when, now time.Time) string {
+	// at runs jobs on the minute, so a time within the current minute is not
+	// in the past yet
+	if when.Before(now.Truncate(time.Minute)) {
+		return "Scheduled time " + when.Format(time.RFC3339) + " is in the past"
+	}
+	if window := maxScheduleWindow(); window > 0 && when.After(now.Add(window)) {
+		return "Scheduled time " + when.Format(time.RFC3339) + " is more than " + strconv.Itoa(int(window.Hours()/24)) + " days ahead"
+	}
+	return ""
+}
+
+// Resolves the common forms of an at time spec against now: a time of day
+// (HH:MM, HHMM, 5pm, noon, midnight, teatime) or now, optionally followed by
+// today, tomorrow or a date (YYYY-MM-DD, MM/DD/YYYY, DD.MM.YYYY), and by an
+// increment such as "+ 2 hours". A time of day without a date that has
+// passed means tomorrow, as for at. Other forms are not understood and
+// report false.
+func parseAtTime(spec string, now time.Time) (time.Time, bool) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	increment := ""
+	if index := strings.Index(spec, "+"); index >= 0 {
+		spec, increment = strings.TrimSpace(spec[:index]), strings.TrimSpace(spec[index:])
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return time.Time{}, false
+	}
+	// "5 pm" is the same as "5pm"
+	if len(fields) > 1 && (fields[1] == "am" || fields[1] == "pm") {
+		fields = append([]string{fields[0] + fields[1]}, fields[2:]...)
+	}
+
+	var when time.Time
+	timeOfDay := true
+	switch fields[0] {
+	case "now":
+		when, timeOfDay = now, false
+	case "noon":
+		when = time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, now.Location())
+	case "midnight":
+		when = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	case "teatime":
+		when = time.Date(now.Year(), now.Month(), now.Day(), 16, 0, 0, 0, now.Location())
+	default:
+		match := atClockRe.FindStringSubmatch(fields[0])
+		if match == nil {
+			return time.Time{}, false
+		}
+		hour, _ := strconv.Atoi(match[1])
+		minute, _ := strconv.Atoi(match[2])
+		if match[3] != "" {
+			if hour < 1 || hour > 12 {
+				return time.Time{}, false
+			}
+			hour %= 12
+			if match[3] == "pm" {
+				hour += 12
+			}
+		}
+		if hour > 23 || minute > 59 {
+			return time.Time{}, false
+		}
+		when = time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	}
+
+	dated := false
+	switch rest := fields[1:]; {
+	case len(rest) == 0:
+	case len(rest) == 1 && rest[0] == "today":
+		dated = true
+	case len(rest) == 1 && rest[0] == "tomorrow":
+		when = when.AddDate(0, 0, 1)
+		dated = true
+	case len(rest) == 1:
+		var date time.Time
+		var err error
+		for _, layout := range []string{"2006-01-02", "01/02/2006", "02.01.2006"} {
+			if date, err = time.ParseInLocation(layout, rest[0], now.Location()); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return time.Time{}, false
+		}
+		when = time.Date(date.Year(), date.Month(), date.Day(), when.Hour(), when.Minute(), 0, 0, now.Location())
+		dated = true
+	default:
+		return time.Time{}, false
+	}
+	if timeOfDay && !dated && when.Before(now.Truncate(time.Minute)) {
+		when = when.AddDate(0, 0, 1)
+	}
+
+	if increment != "" {
+		match := atIncrementRe.FindStringSubmatch(increment)
+		if match == nil {
+			return time.Time{}, false
+		}
+		count, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch match[2] {
+		case "minute":
+			when = when.Add(time.Duration(count) * time.Minute)
+		case "hour":
+			when = when.Add(time.Duration(count) * time.Hour)
+		case "day":
+			when = when.AddDate(0, 0, count)
+		case "week":
+			when = when.AddDate(0, 0, 7*count)
+		case "month":
+			when = when.AddDate(0, count, 0)
+		case "year":
+			when = when.AddDate(count, 0, 0)
+		}
+	}
+	return when, true
+}
+
+// Checks an at time spec against the schedule window, reporting whether it
+// could be resolved at all
+func atScheduleProblem(spec string) (string, bool) {
+	now := time.Now()
+	when, ok := parseAtTime(spec, now)
+	if !ok {
+		return "", false
+	}
+	return scheduleWindowProblem(when, now), true
+}
+
+// Checks the execution time at reported for a new job against the schedule
+// window
+func reportedScheduleProblem(stderr string) string {
+	match := atJobTimeRe.FindStringSubmatch(stderr)
+	if match == nil {
+		return ""
+	}
+	when, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", strings.TrimSpace(match[1]), time.Local)
+	if err != nil {
+		return ""
+	}
+	return scheduleWindowProblem(when, time.Now())
+}
+
+// Checks a systemd-run "+duration" time against the schedule window.
+// Calendar expressions are checked by calendarScheduleProblem.
+func relativeScheduleProblem(spec string) string {
+	if !strings.HasPrefix(spec, "+") {
+		return ""
+	}
+	seconds, ok := parseTimespan(strings.TrimPrefix(spec, "+"))
+	if !ok {
+		return ""
+	}
+	now := time.Now()
+	return scheduleWindowProblem(now.Add(time.Duration(seconds*float64(time.Second))), now)
+}
+
+var calendarElapseRe = regexp.MustCompile(`(?m)^\s*(Next elapse|\(in UTC\)): (.+)$`)
+
+// Checks a systemd-run calendar expression against the schedule window, using
+// the next elapse systemd-analyze calendar computes for it. Returns
+// systemd-analyze's stderr and error when it could not resolve the expression.
+func calendarScheduleProblem(ctx context.Context, spec string) (string, string, error) {
+	out, stderr, err := runCommand(ctx, "systemd-analyze", "calendar", "--iterations=1", "--", spec)
+	if err != nil {
+		return "", stderr, err
+	}
+	elapse := map[string]string{}
+	for _, match := range calendarElapseRe.FindAllStringSubmatch(out, -1) {
+		elapse[match[1]] = strings.TrimSpace(match[2])
+	}
+	if elapse["Next elapse"] == "never" {
+		return "Calendar expression " + spec + " never elapses", "", nil
+	}
+
+	// The UTC line is only printed when the local zone is not UTC
+	when, err := time.Parse("Mon 2006-01-02 15:04:05 MST", elapse["(in UTC)"])
+	if err != nil {
+		when, err = time.ParseInLocation("Mon 2006-01-02 15:04:05 MST", elapse["Next elapse"], time.Local)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("unexpected systemd-analyze calendar output: %q", out)
+	}
+	return scheduleWindowProblem(when, time.Now()), "", nil
+}
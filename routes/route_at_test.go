@@ -0,0 +1,150 @@
+// routes/route_at_test.go
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAtq(t *testing.T) {
+	got := parseAtq("12\tThu Jul  4 12:00:00 2024 a alice\n7\tFri Jul  5 08:30:00 2024 = alice\n\n")
+	if len(got) != 2 || !got["12"] || !got["7"] {
+		t.Errorf("parseAtq() = %v, want jobs 12 and 7", got)
+	}
+	if got := parseAtq(""); len(got) != 0 {
+		t.Errorf("parseAtq(\"\") = %v, want no jobs", got)
+	}
+}
+
+func TestSweepAtOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		atq      fakeResult
+		wantLeft []string
+	}{
+		{
+			name:     "atq available",
+			atq:      fakeResult{stdout: "3\tMon Jul  1 12:00:00 2030 a alice\n"},
+			wantLeft: []string{"2.job", "3.job", "recent.out"},
+		},
+		{
+			// Pending jobs cannot be told from lost ones, so no link goes
+			name:     "atq failing",
+			atq:      fakeResult{stderr: "Can't open /var/run/atd.pid", err: fakeExitError(1)},
+			wantLeft: []string{"1.job", "2.job", "3.job", "4.job", "recent.out"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeRunner(t, map[string]fakeResult{"atq": tt.atq})
+			dir := t.TempDir()
+			old := time.Now().Add(-atOutputRetention - time.Hour)
+			for name, modified := range map[string]time.Time{"old.out": old, "recent.out": time.Now()} {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte("output\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, modified, modified); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// 1 ran long ago, 2 recently, 3 is still queued far ahead and 4
+			// left the queue without running
+			for link, target := range map[string]string{"1.job": "old.out", "2.job": "recent.out", "3.job": "pending.out", "4.job": "lost.out"} {
+				if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sweepAtOutput(context.Background(), dir)
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			left := []string{}
+			for _, entry := range entries {
+				left = append(left, entry.Name())
+			}
+			if strings.Join(left, ",") != strings.Join(tt.wantLeft, ",") {
+				t.Errorf("left %q, want %q", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+// fakeRunner that also accepts every systemd-run call, whose unit name is
+// random
+type systemdRunRunner struct {
+	fakeRunner
+}
+
+func (s *systemdRunRunner) Run(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	if name == "systemd-run" {
+		s.mu.Lock()
+		s.calls = append(s.calls, name)
+		s.mu.Unlock()
+		return nil, nil, nil
+	}
+	return s.fakeRunner.Run(ctx, name, args...)
+}
+
+// systemd-analyze calendar output for an expression elapsing at when
+func calendarOutput(spec string, when time.Time) string {
+	return "  Original form: " + spec + "\n" +
+		"    Next elapse: " + when.In(time.FixedZone("CEST", 2*60*60)).Format("Mon 2006-01-02 15:04:05 MST") + "\n" +
+		"       (in UTC): " + when.UTC().Format("Mon 2006-01-02 15:04:05 MST") + "\n"
+}
+
+func TestScheduleTaskSystemdRunCalendar(t *testing.T) {
+	restoreSettings(t)
+	maxScheduleDays = 30
+	now := time.Now()
+	const analyze = "systemd-analyze calendar --iterations=1 -- "
+	results := map[string]fakeResult{
+		analyze + "tomorrow":   {stdout: calendarOutput("tomorrow", now.AddDate(0, 0, 1))},
+		analyze + "later":      {stdout: calendarOutput("later", now.AddDate(0, 0, 60))},
+		analyze + "2020-01-01": {stdout: "  Original form: 2020-01-01\n    Next elapse: never\n"},
+		analyze + "bogus":      {stderr: "Failed to parse calendar specification 'bogus': Invalid argument\n", err: fakeExitError(1)},
+	}
+
+	tests := []struct {
+		time        string
+		wantStatus  int
+		wantAnalyze bool
+	}{
+		{"tomorrow", http.StatusOK, true},
+		{"later", http.StatusBadRequest, true},
+		{"2020-01-01", http.StatusBadRequest, true},
+		{"bogus", http.StatusBadRequest, true},
+		{"+1h", http.StatusOK, false},
+		{"+90d", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.time, func(t *testing.T) {
+			runner := &systemdRunRunner{fakeRunner{results: results}}
+			previous := SetCommandRunner(runner)
+			defer SetCommandRunner(previous)
+
+			w := httptest.NewRecorder()
+			query := url.Values{"time": {tt.time}, "command": {"true"}, "backend": {"systemd-run"}}
+			ScheduleTask(w, httptest.NewRequest("POST", "/system/at?"+query.Encode(), nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := runner.ran(analyze + tt.time); got != tt.wantAnalyze {
+				t.Errorf("systemd-analyze ran = %v, want %v", got, tt.wantAnalyze)
+			}
+			if got := runner.ran("systemd-run"); got != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("systemd-run ran = %v for status %d", got, w.Code)
+			}
+		})
+	}
+}
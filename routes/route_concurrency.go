@@ -0,0 +1,138 @@
+// routes/route_concurrency.go
+
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bounds on the /system requests that run external programs. At most
+// commandConcurrency run at once; up to commandQueueSize more wait for a slot
+// for commandQueueTimeout before being answered with 503. Set through
+// COMMAND_CONCURRENCY, COMMAND_QUEUE_SIZE and COMMAND_QUEUE_TIMEOUT, read by
+// LoadSettings.
+var (
+	commandConcurrency  = 16
+	commandQueueSize    = 64
+	commandQueueTimeout = 10 * time.Second
+
+	commandSlots   chan struct{}
+	commandWaiting int32
+)
+
+// Requests a single login session may have in flight below /system, set
+// through SESSION_CONCURRENCY; 0 turns the limit off. Checked before the
+// command queue so one client cannot fill it for everyone else.
+var (
+	sessionConcurrency = 8
+
+	sessionInFlight   = map[string]int{}
+	sessionInFlightMu sync.Mutex
+)
+
+// Routes that stream for as long as the client stays connected, and those
+// that only do so with follow=true
+var (
+	streamingRoutes = map[string]bool{
+		"/logs/errors/stream":   true,
+		"/services/watch":       true,
+		"/services/logs/export": true,
+	}
+	followRoutes = map[string]bool{
+		"/services/logs": true,
+		"/tail":          true,
+	}
+)
+
+// Streams and long polls hold their slot for as long as the client stays
+// connected and are bounded by their own limits instead
+func isStreamingRequest(routePath string, r *http.Request) bool {
+	return streamingRoutes[routePath] ||
+		(followRoutes[routePath] && r.URL.Query().Get("follow") == "true")
+}
+
+// Waits for a command slot, answering 503 when the queue is full or the wait
+// exceeds commandQueueTimeout
+func commandLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routePath, ok := systemRoutePath(r)
+		if !ok || commandSlots == nil || routeCapability(routePath) == "" || isStreamingRequest(routePath, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case commandSlots <- struct{}{}:
+		default:
+			if atomic.AddInt32(&commandWaiting, 1) > int32(commandQueueSize) {
+				atomic.AddInt32(&commandWaiting, -1)
+				w.Header().Set("Retry-After", "1")
+				writeJSONError(w, http.StatusServiceUnavailable, "Too many commands running", "command queue is full")
+				return
+			}
+			timer := time.NewTimer(commandQueueTimeout)
+			select {
+			case commandSlots <- struct{}{}:
+				timer.Stop()
+				atomic.AddInt32(&commandWaiting, -1)
+			case <-timer.C:
+				atomic.AddInt32(&commandWaiting, -1)
+				w.Header().Set("Retry-After", "1")
+				writeJSONError(w, http.StatusServiceUnavailable, "Too many commands running", "timed out waiting for a command slot")
+				return
+			case <-r.Context().Done():
+				timer.Stop()
+				atomic.AddInt32(&commandWaiting, -1)
+				return
+			}
+		}
+		defer func() { <-commandSlots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Identifies the login session behind r by the session ID issued at login,
+// which stays the same across token refreshes, falling back to the username
+func requestSession(r *http.Request) string {
+	if session, _ := r.Context().Value("session").(string); session != "" {
+		return "session:" + session
+	}
+	user, _ := r.Context().Value("user").(string)
+	return "user:" + user
+}
+
+// Answers 429 when the requesting session already has sessionConcurrency
+// requests in flight
+func sessionLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routePath, ok := systemRoutePath(r)
+		if !ok || sessionConcurrency == 0 || isStreamingRequest(routePath, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session := requestSession(r)
+		sessionInFlightMu.Lock()
+		if sessionInFlight[session] >= sessionConcurrency {
+			sessionInFlightMu.Unlock()
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(w, http.StatusTooManyRequests, "Too many concurrent requests", "at most "+strconv.Itoa(sessionConcurrency)+" requests per session may run at once")
+			return
+		}
+		sessionInFlight[session]++
+		sessionInFlightMu.Unlock()
+
+		defer func() {
+			sessionInFlightMu.Lock()
+			if sessionInFlight[session]--; sessionInFlight[session] == 0 {
+				delete(sessionInFlight, session)
+			}
+			sessionInFlightMu.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+// routes/route_concurrency_test.go
+
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLimitsSkipOnlyStreamingRequests(t *testing.T) {
+	previousSlots, previousSessions := commandSlots, sessionConcurrency
+	commandSlots, sessionConcurrency = make(chan struct{}, 4), 4
+	defer func() { commandSlots, sessionConcurrency = previousSlots, previousSessions }()
+
+	// Records the command slots and session requests in use while the
+	// handler runs
+	var slots, sessions int
+	record := func(w http.ResponseWriter, r *http.Request) {
+		slots = len(commandSlots)
+		sessionInFlightMu.Lock()
+		sessions = sessionInFlight[requestSession(r)]
+		sessionInFlightMu.Unlock()
+	}
+	router := mux.NewRouter()
+	systemRouter := router.PathPrefix("/system").Subrouter()
+	systemRouter.Use(sessionLimitMiddleware)
+	systemRouter.Use(commandLimitMiddleware)
+	for _, path := range []string{"/services/restart", "/power", "/services/logs", "/services/watch", "/logs/errors/stream"} {
+		systemRouter.HandleFunc(path, record)
+	}
+
+	tests := []struct {
+		target   string
+		wantHeld bool
+	}{
+		{"/system/services/restart", true},
+		{"/system/services/restart?follow=true", true},
+		{"/system/power?follow=true", true},
+		{"/system/services/logs", true},
+		{"/system/services/logs?follow=true", false},
+		{"/system/services/watch", false},
+		{"/system/logs/errors/stream", false},
+	}
+	for _, tt := range tests {
+		slots, sessions = -1, -1
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", tt.target, nil))
+		want := 0
+		if tt.wantHeld {
+			want = 1
+		}
+		if slots != want || sessions != want {
+			t.Errorf("%s: %d command slots and %d session requests held, want %d", tt.target, slots, sessions, want)
+		}
+	}
+}
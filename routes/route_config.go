@@ -0,0 +1,167 @@
+// routes/route_config.go
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var configKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// Serializes read-modify-write cycles on the config file
+var configFileMu sync.Mutex
+
+// The env file edited by the config endpoints, CONFIG_FILE
+var configFile string
+
+// Resolves CONFIG_FILE, the env file edited by the config endpoints, inside
+// the requesting user's sandbox. Relative paths are taken relative to the
+// sandbox root.
+func configFilePath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if configFile == "" {
+		http.Error(w, "No config file is configured, set CONFIG_FILE", http.StatusNotFound)
+		return "", false
+	}
+	fullPath, err := resolveSandboxPath(r, configFile, "")
+	if err != nil {
+		writeSandboxError(w, err)
+		return "", false
+	}
+	return fullPath, true
+}
+
+// Returns the key assigned on an env file line, or "" for blank lines,
+// comments and anything else that is not an assignment
+func configLineKey(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return ""
+	}
+	name, _, found := strings.Cut(line, "=")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(name, "export "))
+}
+
+// Formats a value for an env file, double-quoting it when it holds
+// characters that splitQuoted would otherwise interpret
+func quoteConfigValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\#;$`") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// Sets key in the env file content, replacing the last assignment of it, the
+// one that takes effect, and appending one when there is none. Comments,
+// ordering and every other line are left as they are.
+func setConfigValue(content, key, value string) string {
+	lines := strings.Split(content, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if configLineKey(lines[i]) != key {
+			continue
+		}
+		prefix := ""
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "export ") {
+			prefix = "export "
+		}
+		lines[i] = prefix + key + "=" + quoteConfigValue(value)
+		return strings.Join(lines, "\n")
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + key + "=" + quoteConfigValue(value) + "\n"
+}
+
+func GetConfig(w http.ResponseWriter, r *http.Request) {
+	fullPath, ok := configFilePath(w, r)
+	if !ok {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key != "" && !configKeyRe.MatchString(key) {
+		http.Error(w, "Invalid key name", http.StatusBadRequest)
+		return
+	}
+
+	values, err := readEnvironmentFile(fullPath)
+	if os.IsNotExist(err) {
+		values = map[string]string{}
+	} else if err != nil {
+		http.Error(w, "Error reading config file", http.StatusInternalServerError)
+		return
+	}
+
+	if key == "" {
+		if r.URL.Query().Get("redact") == "true" {
+			redactVariables(values)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"values": values,
+		})
+		return
+	}
+	value, found := values[key]
+	if !found {
+		http.Error(w, "Key "+key+" is not set", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"key":   key,
+		"value": value,
+	})
+}
+
+func SetConfig(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Key   string  `json:"key"`
+		Value *string `json:"value"`
+	}
+	if !decodeJSONBody(w, r, &request) {
+		return
+	}
+	if !configKeyRe.MatchString(request.Key) {
+		http.Error(w, "Invalid key name", http.StatusBadRequest)
+		return
+	}
+	if request.Value == nil {
+		http.Error(w, "value is required", http.StatusBadRequest)
+		return
+	}
+	if strings.ContainsAny(*request.Value, "\n\r\x00") {
+		http.Error(w, "value cannot contain line breaks", http.StatusBadRequest)
+		return
+	}
+	fullPath, ok := configFilePath(w, r)
+	if !ok {
+		return
+	}
+
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+	content, err := os.ReadFile(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, "Error reading config file", http.StatusInternalServerError)
+		return
+	}
+	updated := setConfigValue(string(content), request.Key, *request.Value)
+	if err := writeFileAtomic(fullPath, []byte(updated)); err != nil {
+		http.Error(w, "Error saving config file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Key " + request.Key + " set successfully",
+	})
+}
@@ -0,0 +1,140 @@
+// routes/route_download.go
+
+package routes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDownloadLinkTTL = 15 * time.Minute
+	maxDownloadLinkTTL     = 24 * time.Hour
+)
+
+// Key signing download links, DOWNLOAD_LINK_SECRET read by LoadSettings or a
+// random key made on first use, in which case links stop working when the
+// server restarts
+var (
+	downloadLinkKey     []byte
+	downloadLinkKeyOnce sync.Once
+)
+
+func downloadKey() []byte {
+	downloadLinkKeyOnce.Do(func() {
+		if downloadLinkKey != nil {
+			return
+		}
+		downloadLinkKey = make([]byte, 32)
+		if _, err := rand.Read(downloadLinkKey); err != nil {
+			log.Fatalf("Error generating download link key: %v", err)
+		}
+	})
+	return downloadLinkKey
+}
+
+// Signs the user, the resolved path and the expiry time of a download link
+func signDownload(user, path string, expires int64) string {
+	mac := hmac.New(sha256.New, downloadKey())
+	mac.Write([]byte(user + "\n" + path + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func SignDownload(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	filepath := r.URL.Query().Get("filepath")
+	if filename == "" || filepath == "" {
+		http.Error(w, "Filename and filepath are required", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultDownloadLinkTTL
+	if value := r.URL.Query().Get("expires_in"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxDownloadLinkTTL {
+			http.Error(w, "expires_in must be between 1 and "+strconv.Itoa(int(maxDownloadLinkTTL.Seconds()))+" seconds", http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	fullPath, err := resolveSandboxPath(r, filepath, filename)
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File "+filename+" at "+filepath+" does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading file "+filename+" at "+filepath, http.StatusInternalServerError)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		http.Error(w, "File "+filename+" at "+filepath+" is not a regular file", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := r.Context().Value("user").(string)
+	expires := time.Now().Add(ttl).Unix()
+	query := url.Values{}
+	query.Set("path", fullPath)
+	query.Set("user", user)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signDownload(user, fullPath, expires))
+	// The download route sits next to this one, under the same prefix
+	link := strings.TrimSuffix(r.URL.Path, "/sign-download") + "/download?" + query.Encode()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        link,
+		"expires_at": time.Unix(expires, 0).UTC().Format(time.RFC3339),
+	})
+}
+
+// SignedDownload serves a file named by a link from SignDownload. It is
+// registered outside the authenticated routes: the signature stands in for
+// the session, and the path is checked against the signing user's sandbox
+// again in case it changed since.
+func SignedDownload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path, user, signature := query.Get("path"), query.Get("user"), query.Get("signature")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if path == "" || signature == "" || err != nil {
+		http.Error(w, "Invalid download link", http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(signature), []byte(signDownload(user, path, expires))) {
+		http.Error(w, "Invalid download link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(w, "Download link has expired", http.StatusGone)
+		return
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), "user", user))
+	fullPath, err := resolveSandboxPath(r, path, "")
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+		http.Error(w, "File no longer exists", http.StatusNotFound)
+		return
+	}
+	serveRawFile(w, r, fullPath, true)
+}
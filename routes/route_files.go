@@ -0,0 +1,767 @@
+// routes/route_files.go
+
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// Maximum number of files accepted by a single batch read
+	maxBatchFiles = 20
+	// Maximum combined size of the files returned by a batch read
+	maxBatchBytes = 5 << 20
+)
+
+var errOutsideSandbox = errors.New("path is outside the sandbox")
+
+// Sandbox settings read by LoadSettings: the shared root (SANDBOX_ROOT), the
+// roots of individual users (SANDBOX_ROOTS, user=/path pairs) and whether
+// ownership may be changed (ALLOW_CHOWN=true)
+var (
+	sandboxRootSetting string
+	userSandboxRoots   = map[string]string{}
+	allowChown         bool
+)
+
+// Looks up the root assigned to user in SANDBOX_ROOTS. A user listed with a
+// relative path gets an error rather than the shared root, which would give
+// them more than intended.
+func userSandboxRoot(user string) (string, error) {
+	root, ok := userSandboxRoots[user]
+	if !ok {
+		return "", nil
+	}
+	if !filepath.IsAbs(root) {
+		return "", fmt.Errorf("sandbox root of %s must be an absolute path", user)
+	}
+	return root, nil
+}
+
+// Returns the directory the authenticated user's file requests are confined
+// to: their entry in SANDBOX_ROOTS, otherwise the shared SANDBOX_ROOT, which
+// defaults to the server user's home directory
+func sandboxRoot(r *http.Request) (string, error) {
+	user, _ := r.Context().Value("user").(string)
+	root, err := userSandboxRoot(user)
+	if err != nil {
+		return "", err
+	}
+	if root == "" {
+		root = sandboxRootSetting
+	}
+	if root == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		root = home
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// Resolves symlinks in the longest existing prefix of path so that links
+// pointing out of the sandbox are caught even when the leaf does not exist yet
+func resolveExisting(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolvedParent, err := resolveExisting(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
+
+// Joins dir and name and checks that the result lies inside the sandbox root
+// of the requesting user. Relative directories are taken relative to the root.
+func resolveSandboxPath(r *http.Request, dir, name string) (string, error) {
+	root, err := sandboxRoot(r)
+	if err != nil {
+		return "", err
+	}
+	fullPath := filepath.Join(dir, name)
+	if !filepath.IsAbs(fullPath) {
+		fullPath = filepath.Join(root, fullPath)
+	}
+	resolved, err := resolveExisting(filepath.Clean(fullPath))
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(root, resolved) {
+		return "", errOutsideSandbox
+	}
+	return resolved, nil
+}
+
+// Reports whether path lies within root
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Like resolveSandboxPath, but leaves a symlink in the last element unresolved
+// so that operations on the entry itself, such as a rename, act on the link
+// rather than on what it points to
+func resolveSandboxEntry(r *http.Request, path string) (string, error) {
+	path = filepath.Clean(path)
+	base := filepath.Base(path)
+	if base == "." || base == ".." || base == string(filepath.Separator) {
+		return resolveSandboxPath(r, path, "")
+	}
+	parent, err := resolveSandboxPath(r, filepath.Dir(path), "")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, base), nil
+}
+
+// Writes the error for a path that failed sandbox resolution
+func writeSandboxError(w http.ResponseWriter, err error) {
+	if err == errOutsideSandbox {
+		http.Error(w, "Path is outside the allowed directory", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Error resolving path", http.StatusInternalServerError)
+}
+
+type batchFile struct {
+	Filepath string `json:"filepath"`
+	Filename string `json:"filename"`
+}
+
+type batchResult struct {
+	Filepath string  `json:"filepath"`
+	Filename string  `json:"filename"`
+	Content  *string `json:"content,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+var errBatchLimit = errors.New("batch size limit exceeded")
+
+// Reads the file at fullPath, failing with errBatchLimit once more than limit
+// bytes come out of it. The limit is applied while reading, so a file that
+// grows after being opened cannot slip past it.
+func readLimited(fullPath string, limit int64) ([]byte, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("is a directory")
+	}
+	if info.Size() > limit {
+		return nil, errBatchLimit
+	}
+	content, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, errBatchLimit
+	}
+	return content, nil
+}
+
+func ReadFileBatch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Files []batchFile `json:"files"`
+	}
+	if !decodeJSONBody(w, r, &request) {
+		return
+	}
+	if len(request.Files) == 0 {
+		http.Error(w, "At least one file is required", http.StatusBadRequest)
+		return
+	}
+	if len(request.Files) > maxBatchFiles {
+		http.Error(w, "Too many files requested", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]batchResult, 0, len(request.Files))
+	remaining := int64(maxBatchBytes)
+	for _, file := range request.Files {
+		result := batchResult{Filepath: file.Filepath, Filename: file.Filename}
+		results = append(results, result)
+		current := &results[len(results)-1]
+
+		if file.Filepath == "" || file.Filename == "" {
+			current.Error = "Filename and filepath are required"
+			continue
+		}
+		fullPath, err := resolveSandboxPath(r, file.Filepath, file.Filename)
+		if err != nil {
+			if err == errOutsideSandbox {
+				current.Error = "Path is outside the allowed directory"
+			} else {
+				current.Error = "Error resolving path"
+			}
+			continue
+		}
+		fileContent, err := readLimited(fullPath, remaining)
+		if err == errBatchLimit {
+			current.Error = "Batch size limit exceeded"
+			continue
+		}
+		if err != nil {
+			current.Error = "Error reading file " + file.Filename + " at " + file.Filepath
+			continue
+		}
+		remaining -= int64(len(fileContent))
+		content := string(fileContent)
+		current.Content = &content
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": results,
+	})
+}
+
+// Appends content to the file, creating it if needed, and returns the new
+// total size. O_APPEND makes each write land at the current end of file even
+// with concurrent writers.
+func appendToFile(fullPath string, content []byte) (int64, error) {
+	file, err := os.OpenFile(fullPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Replaces the file's content through a temporary file in the same directory
+// renamed over it, so readers see either the old or the new content in full.
+// An existing file keeps its mode and, where permitted, its owner; new files
+// get 0644.
+func writeFileAtomic(fullPath string, content []byte) error {
+	mode := os.FileMode(0644)
+	info, err := os.Stat(fullPath)
+	switch {
+	case err == nil && info.IsDir():
+		return fmt.Errorf("%s is a directory", fullPath)
+	case err == nil:
+		mode = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tempPath := temp.Name()
+	defer os.Remove(tempPath)
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempPath, mode); err != nil {
+		return err
+	}
+	if info != nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			os.Lchown(tempPath, int(stat.Uid), int(stat.Gid))
+		}
+	}
+	return os.Rename(tempPath, fullPath)
+}
+
+// Parses an optional non-negative integer query parameter, returning fallback
+// when it is absent
+func rangeParam(r *http.Request, name string, fallback int64) (int64, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, true
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		return 0, false
+	}
+	return parsed, true
+}
+
+// Reads lines start to end (1-indexed, inclusive) and counts the lines of the
+// whole file. A final line without a trailing newline still counts.
+func readLines(fullPath string, start, end int64) (string, int64, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	var content strings.Builder
+	var total int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			total++
+			if total >= start && total <= end {
+				content.WriteString(line)
+			}
+		}
+		if err == io.EOF {
+			return content.String(), total, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+	}
+}
+
+// Serves part of a file selected by startLine/endLine or offset/length, with
+// the total size for the client to page through the rest. A range starting
+// past the end of the file is answered with 416; one running past the end is
+// cut short.
+func readFileRange(w http.ResponseWriter, r *http.Request, fullPath string, byLine bool) {
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	if byLine {
+		start, okStart := rangeParam(r, "startLine", 1)
+		end, okEnd := rangeParam(r, "endLine", math.MaxInt64)
+		if !okStart || !okEnd || start < 1 {
+			http.Error(w, "startLine and endLine must be positive integers", http.StatusBadRequest)
+			return
+		}
+		if end < start {
+			http.Error(w, "endLine must not be before startLine", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		content, total, err := readLines(fullPath, start, end)
+		if err != nil {
+			http.Error(w, "Error reading file", http.StatusInternalServerError)
+			return
+		}
+		if start > total {
+			http.Error(w, "startLine is beyond the end of the file ("+strconv.FormatInt(total, 10)+" lines)", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end > total {
+			end = total
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content":     content,
+			"start_line":  start,
+			"end_line":    end,
+			"total_lines": total,
+			"size":        info.Size(),
+		})
+		return
+	}
+
+	size := info.Size()
+	offset, okOffset := rangeParam(r, "offset", 0)
+	length, okLength := rangeParam(r, "length", size)
+	if !okOffset || !okLength {
+		http.Error(w, "offset and length must be non-negative integers", http.StatusBadRequest)
+		return
+	}
+	if offset > size || (offset == size && size > 0) {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		http.Error(w, "offset is beyond the end of the file ("+strconv.FormatInt(size, 10)+" bytes)", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if length > size-offset {
+		length = size - offset
+	}
+	if length > maxBatchBytes {
+		http.Error(w, "length exceeds the maximum of "+strconv.Itoa(maxBatchBytes)+" bytes", http.StatusBadRequest)
+		return
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	buf := make([]byte, length)
+	if _, err := file.ReadAt(buf, offset); err != nil && err != io.EOF {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"content": string(buf),
+		"offset":  offset,
+		"length":  length,
+		"size":    size,
+	})
+}
+
+func MoveFile(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if !decodeJSONBody(w, r, &request) {
+		return
+	}
+	if request.From == "" || request.To == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+
+	from, err := resolveSandboxEntry(r, request.From)
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	to, err := resolveSandboxEntry(r, request.To)
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	root, err := sandboxRoot(r)
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	if from == root || to == root {
+		http.Error(w, "The sandbox root cannot be moved or replaced", http.StatusForbidden)
+		return
+	}
+
+	if _, err := os.Lstat(from); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Source "+request.From+" does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading source "+request.From, http.StatusInternalServerError)
+		return
+	}
+	if _, err := os.Lstat(to); err == nil && !overwrite {
+		http.Error(w, "Destination "+request.To+" already exists, pass overwrite=true to replace it", http.StatusConflict)
+		return
+	}
+
+	if err := os.Rename(from, to); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			http.Error(w, "Cannot move across filesystems", http.StatusBadRequest)
+			return
+		}
+		if os.IsNotExist(err) {
+			http.Error(w, "Destination directory of "+request.To+" does not exist", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, syscall.EISDIR) || errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+			http.Error(w, "Cannot replace "+request.To+" with "+request.From, http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error moving "+request.From+" to "+request.To, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Moved " + request.From + " to " + request.To,
+	})
+}
+
+// Creates the missing directories leading up to fullPath
+func createParentDirs(fullPath string) error {
+	return os.MkdirAll(filepath.Dir(fullPath), 0755)
+}
+
+func MakeDirectory(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("filepath")
+	if dir == "" {
+		http.Error(w, "Filepath is required", http.StatusBadRequest)
+		return
+	}
+	parents := r.URL.Query().Get("parents") == "true"
+
+	fullPath, err := resolveSandboxPath(r, dir, "")
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	if _, err := os.Lstat(fullPath); err == nil {
+		http.Error(w, dir+" already exists", http.StatusConflict)
+		return
+	}
+
+	if parents {
+		err = os.MkdirAll(fullPath, 0755)
+	} else {
+		err = os.Mkdir(fullPath, 0755)
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			http.Error(w, dir+" already exists", http.StatusConflict)
+			return
+		}
+		if os.IsNotExist(err) {
+			http.Error(w, "Parent directory of "+dir+" does not exist, pass parents=true to create it", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error creating directory "+dir, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Directory " + dir + " created",
+	})
+}
+
+// Picks the Content-Type of a file from its extension, falling back to
+// sniffing its first 512 bytes
+func detectContentType(fullPath string, file *os.File) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(fullPath)); contentType != "" {
+		return contentType, nil
+	}
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// Sends the file itself rather than JSON, with its detected type. Range and
+// conditional requests are handled by http.ServeContent.
+func serveRawFile(w http.ResponseWriter, r *http.Request, fullPath string, download bool) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	contentType, err := detectContentType(fullPath, file)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	// Files are shown inline on the API's origin, so keep any HTML or SVG
+	// among them from running scripts there
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if download {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": filepath.Base(fullPath),
+		}))
+	}
+	http.ServeContent(w, r, filepath.Base(fullPath), info.ModTime(), file)
+}
+
+// Converts a Unix permission value such as 04755 to an os.FileMode
+func fileModeFromUnix(mode uint64) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+// Formats the permission bits of info as a Unix octal mode such as "0644"
+func octalMode(info os.FileInfo) string {
+	mode := uint32(info.Mode().Perm())
+	if info.Mode()&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if info.Mode()&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if info.Mode()&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return fmt.Sprintf("%04o", mode)
+}
+
+// Describes the mode and ownership of a file
+func permissionInfo(info os.FileInfo) map[string]interface{} {
+	result := map[string]interface{}{
+		"mode": octalMode(info),
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		result["uid"] = stat.Uid
+		result["gid"] = stat.Gid
+	}
+	return result
+}
+
+// Resolves the filepath and filename query parameters of the permission
+// endpoints, answering the request itself on failure
+func permissionTarget(w http.ResponseWriter, r *http.Request) (string, bool) {
+	filename := r.URL.Query().Get("filename")
+	dir := r.URL.Query().Get("filepath")
+	if filename == "" || dir == "" {
+		http.Error(w, "Filename and filepath are required", http.StatusBadRequest)
+		return "", false
+	}
+	fullPath, err := resolveSandboxPath(r, dir, filename)
+	if err != nil {
+		writeSandboxError(w, err)
+		return "", false
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File "+filename+" at "+dir+" does not exist", http.StatusNotFound)
+			return "", false
+		}
+		http.Error(w, "Error reading file "+filename+" at "+dir, http.StatusInternalServerError)
+		return "", false
+	}
+	return fullPath, true
+}
+
+func writePermissions(w http.ResponseWriter, fullPath string) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(permissionInfo(info))
+}
+
+func GetPermissions(w http.ResponseWriter, r *http.Request) {
+	fullPath, ok := permissionTarget(w, r)
+	if !ok {
+		return
+	}
+	writePermissions(w, fullPath)
+}
+
+func ChmodFile(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("mode")
+	if value == "" {
+		http.Error(w, "Mode is required", http.StatusBadRequest)
+		return
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 07777 {
+		http.Error(w, "Mode must be an octal value between 0000 and 7777", http.StatusBadRequest)
+		return
+	}
+	fullPath, ok := permissionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := os.Chmod(fullPath, fileModeFromUnix(mode)); err != nil {
+		if os.IsPermission(err) {
+			http.Error(w, "Not permitted to change the mode", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Error changing the mode", http.StatusInternalServerError)
+		return
+	}
+	writePermissions(w, fullPath)
+}
+
+// Parses a uid or gid query parameter; absent values and -1 leave the id
+// unchanged
+func ownerParam(r *http.Request, name string) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return -1, true
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < -1 {
+		return 0, false
+	}
+	return id, true
+}
+
+func ChownFile(w http.ResponseWriter, r *http.Request) {
+	if !allowChown {
+		http.Error(w, "Changing ownership is disabled", http.StatusForbidden)
+		return
+	}
+	if role, _ := r.Context().Value("role").(string); role != "admin" {
+		http.Error(w, "Changing ownership requires the admin role", http.StatusForbidden)
+		return
+	}
+	uid, okUID := ownerParam(r, "uid")
+	gid, okGID := ownerParam(r, "gid")
+	if !okUID || !okGID {
+		http.Error(w, "uid and gid must be integers", http.StatusBadRequest)
+		return
+	}
+	if uid == -1 && gid == -1 {
+		http.Error(w, "uid or gid is required", http.StatusBadRequest)
+		return
+	}
+	fullPath, ok := permissionTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := os.Chown(fullPath, uid, gid); err != nil {
+		if os.IsPermission(err) {
+			http.Error(w, "Not permitted to change the owner", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Error changing the owner", http.StatusInternalServerError)
+		return
+	}
+	writePermissions(w, fullPath)
+}
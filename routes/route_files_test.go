@@ -0,0 +1,207 @@
+// routes/route_files_test.go
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithinRoot(t *testing.T) {
+	tests := []struct {
+		root string
+		path string
+		want bool
+	}{
+		{"/srv/files", "/srv/files", true},
+		{"/srv/files", "/srv/files/a/b.txt", true},
+		{"/srv/files", "/srv/files/..hidden", true},
+		{"/srv/files", "/srv", false},
+		{"/srv/files", "/srv/files-other/a", false},
+		{"/srv/files", "/etc/passwd", false},
+		{"/srv/files", "relative", false},
+	}
+	for _, tt := range tests {
+		if got := withinRoot(tt.root, tt.path); got != tt.want {
+			t.Errorf("withinRoot(%q, %q) = %v, want %v", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestResolveSandboxPath(t *testing.T) {
+	root := useSandbox(t)
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "conf"), filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		file    string
+		want    string
+		wantErr error
+	}{
+		{"relative", "conf", "app.env", filepath.Join(root, "conf", "app.env"), nil},
+		{"absolute inside", filepath.Join(root, "conf"), "app.env", filepath.Join(root, "conf", "app.env"), nil},
+		{"missing directories", "new/dir", "file", filepath.Join(root, "new", "dir", "file"), nil},
+		{"root itself", ".", "", root, nil},
+		{"symlink inside", "inside", "app.env", filepath.Join(root, "conf", "app.env"), nil},
+		{"parent", "..", "file", "", errOutsideSandbox},
+		{"parent in name", "conf", "../../file", "", errOutsideSandbox},
+		{"absolute outside", "/etc", "passwd", "", errOutsideSandbox},
+		{"symlink outside", "escape", "file", "", errOutsideSandbox},
+		{"missing below symlink outside", "escape/new", "file", "", errOutsideSandbox},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSandboxPath(httptest.NewRequest("GET", "/", nil), tt.dir, tt.file)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("resolveSandboxPath(%q, %q) = %q, %v, want %q, %v", tt.dir, tt.file, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserSandboxRoot(t *testing.T) {
+	root := useSandbox(t)
+	alice := t.TempDir()
+	previous := userSandboxRoots
+	userSandboxRoots = map[string]string{"alice": alice, "bob": "relative/path"}
+	defer func() { userSandboxRoots = previous }()
+
+	tests := []struct {
+		user     string
+		wantRoot string
+		wantErr  bool
+	}{
+		{"alice", alice, false},
+		{"carol", root, false},
+		{"", root, false},
+		{"bob", "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), "user", tt.user))
+		got, err := sandboxRoot(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("sandboxRoot(%q) error = %v, want error %v", tt.user, err, tt.wantErr)
+			continue
+		}
+		if want, _ := filepath.EvalSymlinks(tt.wantRoot); !tt.wantErr && got != want {
+			t.Errorf("sandboxRoot(%q) = %q, want %q", tt.user, got, want)
+		}
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		limit   int64
+		want    string
+		wantErr bool
+	}{
+		{"below the limit", file, 20, "0123456789", false},
+		{"at the limit", file, 10, "0123456789", false},
+		{"above the limit", file, 9, "", true},
+		{"directory", dir, 20, "", true},
+		{"missing", filepath.Join(dir, "missing"), 20, "", true},
+	}
+	for _, tt := range tests {
+		got, err := readLimited(tt.path, tt.limit)
+		if (err != nil) != tt.wantErr || string(got) != tt.want {
+			t.Errorf("%s: readLimited() = %q, %v", tt.name, got, err)
+		}
+		if tt.name == "above the limit" && err != errBatchLimit {
+			t.Errorf("%s: error = %v, want errBatchLimit", tt.name, err)
+		}
+	}
+}
+
+func TestReadFileBatch(t *testing.T) {
+	root := useSandbox(t)
+	if err := os.WriteFile(filepath.Join(root, "a.env"), []byte("A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "big"), []byte(strings.Repeat("x", maxBatchBytes)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"files": [
+		{"filepath": ".", "filename": "a.env"},
+		{"filepath": ".", "filename": "missing"},
+		{"filepath": "/etc", "filename": "passwd"},
+		{"filepath": ".", "filename": "big"}
+	]}`
+	w := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/read-batch", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	ReadFileBatch(w, request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var response struct {
+		Files []batchResult `json:"files"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		content string
+		err     string
+	}{
+		{"A=1\n", ""},
+		{"", "Error reading file missing at ."},
+		{"", "Path is outside the allowed directory"},
+		// a.env used part of the budget, so the big file no longer fits
+		{"", "Batch size limit exceeded"},
+	}
+	if len(response.Files) != len(want) {
+		t.Fatalf("files = %+v", response.Files)
+	}
+	for i, w := range want {
+		got := response.Files[i]
+		content := ""
+		if got.Content != nil {
+			content = *got.Content
+		}
+		if content != w.content || got.Error != w.err {
+			t.Errorf("file %d = %q, %q; want %q, %q", i, content, got.Error, w.content, w.err)
+		}
+	}
+}
+
+func TestReadFileBatchTooManyFiles(t *testing.T) {
+	useSandbox(t)
+	files := make([]string, maxBatchFiles+1)
+	for i := range files {
+		files[i] = `{"filepath": ".", "filename": "a"}`
+	}
+	w := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/read-batch", strings.NewReader(`{"files": [`+strings.Join(files, ",")+`]}`))
+	request.Header.Set("Content-Type", "application/json")
+	ReadFileBatch(w, request)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,156 @@
+// routes/route_health.go
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capabilities probed at startup. systemctl and the user manager are required
+// for the server to be ready; the others only disable the routes using them.
+var (
+	capabilitiesMu sync.RWMutex
+	capabilities   = map[string]bool{}
+)
+
+var requiredCapabilities = []string{"systemctl", "user-manager"}
+
+// Time allowed for each program run while probing capabilities
+const capabilityProbeTimeout = 3 * time.Second
+
+// Capability each /system route depends on, matched as a prefix of the route
+// path below /system; the longest match wins
+var routeCapabilities = map[string]string{
+	"/services":        "systemctl",
+	"/summary":         "systemctl",
+	"/services/logs":   "journalctl",
+	"/logs":            "journalctl",
+	"/services/verify": "systemd-analyze",
+	"/analyze":         "systemd-analyze",
+	"/at":              "at",
+	"/power":           "systemctl",
+}
+
+// CheckCapabilities looks for the external programs the routes rely on and
+// for a running systemd user manager, logging a warning for each one missing
+func CheckCapabilities() map[string]bool {
+	found := map[string]bool{}
+	for _, program := range []string{"systemctl", "journalctl", "systemd-analyze", "systemd-run", "at", "atq", "loginctl"} {
+		_, err := exec.LookPath(program)
+		found[program] = err == nil
+	}
+	// "at" scheduling also needs atq to list and the daemon to run the jobs,
+	// which atq reports on by failing
+	if found["at"] && found["atq"] {
+		ctx, cancel := context.WithTimeout(context.Background(), capabilityProbeTimeout)
+		_, _, err := runCommand(ctx, "atq")
+		cancel()
+		found["at"] = err == nil
+	}
+
+	found["user-manager"] = false
+	if found["systemctl"] {
+		ctx, cancel := context.WithTimeout(context.Background(), capabilityProbeTimeout)
+		defer cancel()
+		out, _, _ := runCommand(ctx, "systemctl", "--user", "is-system-running")
+		state := strings.TrimSpace(out)
+		found["user-manager"] = state != "" && state != "offline" && state != "unknown"
+	}
+
+	for _, name := range []string{"systemctl", "user-manager", "journalctl", "systemd-analyze", "systemd-run", "at", "atq", "loginctl"} {
+		if !found[name] {
+			log.Printf("Warning: %s is not available on this host", name)
+		}
+	}
+
+	capabilitiesMu.Lock()
+	capabilities = found
+	capabilitiesMu.Unlock()
+	return found
+}
+
+func hasCapability(name string) bool {
+	capabilitiesMu.RLock()
+	defer capabilitiesMu.RUnlock()
+	ok, checked := capabilities[name]
+	// Before the startup check everything is assumed to be present
+	return ok || !checked
+}
+
+// Returns the capability a route path below /system depends on, or "" for
+// routes that run no external programs
+func routeCapability(routePath string) string {
+	required, longest := "", 0
+	for prefix, capability := range routeCapabilities {
+		if (routePath == prefix || strings.HasPrefix(routePath, prefix+"/")) && len(prefix) > longest {
+			required, longest = capability, len(prefix)
+		}
+	}
+	return required
+}
+
+// Like routeCapability, for capabilities that depend on the request, such as
+// the scheduling backend of /at or the logout action of /power
+func requestCapability(routePath string, r *http.Request) string {
+	if routePath == "/at" && r.URL.Query().Get("backend") == "systemd-run" {
+		return "systemd-run"
+	}
+	if routePath == "/power" && r.URL.Query().Get("action") == "logout" {
+		return "loginctl"
+	}
+	return routeCapability(routePath)
+}
+
+// Refuses requests to routes whose capability is missing with 503
+func capabilityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routePath, ok := systemRoutePath(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		required := requestCapability(routePath, r)
+		if required != "" && !hasCapability(required) {
+			writeJSONError(w, http.StatusServiceUnavailable, required+" is not available on this host", "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HealthzHandler reports readiness and the capability set. It answers 503
+// while a required capability is missing.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	capabilitiesMu.RLock()
+	snapshot := make(map[string]bool, len(capabilities))
+	for name, ok := range capabilities {
+		snapshot[name] = ok
+	}
+	capabilitiesMu.RUnlock()
+
+	status, code := "ok", http.StatusOK
+	for _, ok := range snapshot {
+		if !ok {
+			status = "degraded"
+		}
+	}
+	for _, name := range requiredCapabilities {
+		if !snapshot[name] {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       status,
+		"capabilities": snapshot,
+	})
+}
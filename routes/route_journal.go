@@ -0,0 +1,347 @@
+// routes/route_journal.go
+
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"napi/components"
+)
+
+const (
+	defaultLogLines = 100
+	maxLogLines     = 1000
+)
+
+type LogEntry struct {
+	Cursor    string `json:"cursor,omitempty"`
+	Timestamp string `json:"timestamp"`
+	Priority  int    `json:"priority"`
+	Unit      string `json:"unit,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Decodes a journal field that journalctl emits either as a string or, for
+// non-UTF-8 data, as an array of bytes
+func journalString(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var data []byte
+	var values []int
+	if err := json.Unmarshal(raw, &values); err == nil {
+		for _, value := range values {
+			data = append(data, byte(value))
+		}
+	}
+	return string(data)
+}
+
+// Converts one line of journalctl -o json output into a LogEntry
+func parseJournalEntry(line []byte) (LogEntry, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return LogEntry{}, err
+	}
+
+	entry := LogEntry{
+		Cursor:  journalString(fields["__CURSOR"]),
+		Message: journalString(fields["MESSAGE"]),
+		Unit:    journalString(fields["_SYSTEMD_USER_UNIT"]),
+	}
+	if entry.Unit == "" {
+		entry.Unit = journalString(fields["USER_UNIT"])
+	}
+	if usec, err := strconv.ParseInt(journalString(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		entry.Timestamp = time.UnixMicro(usec).UTC().Format(time.RFC3339Nano)
+	}
+	entry.Priority = 6
+	if priority, err := strconv.Atoi(journalString(fields["PRIORITY"])); err == nil {
+		entry.Priority = priority
+	}
+	return entry, nil
+}
+
+// Checks a since/until value before it is handed to journalctl
+func validateJournalTime(value string) bool {
+	return len(value) <= 64 && !strings.ContainsAny(value, "\n\r")
+}
+
+// Builds the journalctl arguments shared by the log endpoints from the
+// target, since and until query parameters
+func journalArgs(r *http.Request) ([]string, string) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		return nil, "Service name is required"
+	}
+	if !validateUnitName(service) {
+		return nil, "Invalid service name"
+	}
+	args := []string{"--user", "-u", service, "--no-pager"}
+	if since := r.URL.Query().Get("since"); since != "" {
+		if !validateJournalTime(since) {
+			return nil, "Invalid since value"
+		}
+		args = append(args, "--since="+since)
+	}
+	if until := r.URL.Query().Get("until"); until != "" {
+		if !validateJournalTime(until) {
+			return nil, "Invalid until value"
+		}
+		args = append(args, "--until="+until)
+	}
+	return args, ""
+}
+
+var journalCursorRe = regexp.MustCompile(`^[A-Za-z0-9=;_\-]{1,512}$`)
+
+// Runs journalctl with args through the CommandRunner and parses up to limit
+// entries from its output. journalctl is passed -n limit as well, so it stops
+// after as many entries as are returned.
+func readJournalEntries(r *http.Request, args []string, limit int) ([]LogEntry, error) {
+	out, err := commandOutput(r.Context(), "journalctl", append(args, "-n", strconv.Itoa(limit))...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []LogEntry{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) >= limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// Streams new journal entries as newline-delimited JSON until the client
+// disconnects
+func followJournal(w http.ResponseWriter, r *http.Request, args []string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	cmd := exec.CommandContext(r.Context(), "journalctl", append(args, "-f")...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, "Error fetching logs", http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, "Error fetching logs", http.StatusInternalServerError)
+		return
+	}
+	defer cmd.Wait()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func ServiceLogs(w http.ResponseWriter, r *http.Request) {
+	args, problem := journalArgs(r)
+	if problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	lines := defaultLogLines
+	if value := r.URL.Query().Get("lines"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLogLines {
+			http.Error(w, "lines must be between 1 and "+strconv.Itoa(maxLogLines), http.StatusBadRequest)
+			return
+		}
+		lines = parsed
+	}
+	args = append(args, "-o", "json")
+
+	before := r.URL.Query().Get("before")
+	after := r.URL.Query().Get("after")
+	for _, cursor := range []string{before, after} {
+		if cursor != "" && !journalCursorRe.MatchString(cursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	if before != "" && after != "" {
+		http.Error(w, "before and after cannot be combined", http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("follow") == "true" {
+		if before != "" {
+			http.Error(w, "follow cannot be combined with before", http.StatusBadRequest)
+			return
+		}
+		if after != "" {
+			followJournal(w, r, append(args, "--after-cursor="+after))
+		} else {
+			followJournal(w, r, append(args, "-n", strconv.Itoa(lines)))
+		}
+		return
+	}
+
+	var entries []LogEntry
+	var err error
+	switch {
+	case before != "":
+		// Walk backward from the cursor; the entry at the cursor itself was
+		// already returned in the previous page
+		entries, err = readJournalEntries(r, append(args, "--cursor="+before, "--reverse"), lines+1)
+		if err == nil {
+			if len(entries) > 0 && entries[0].Cursor == before {
+				entries = entries[1:]
+			}
+			if len(entries) > lines {
+				entries = entries[:lines]
+			}
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+	case after != "":
+		entries, err = readJournalEntries(r, append(args, "--after-cursor="+after), lines)
+	default:
+		entries, err = readJournalEntries(r, args, lines)
+	}
+	if err == errCommandTimeout {
+		writeTimeoutError(w, "Timed out fetching logs")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching logs", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"entries": entries,
+	}
+	if len(entries) > 0 {
+		response["first_cursor"] = entries[0].Cursor
+		response["last_cursor"] = entries[len(entries)-1].Cursor
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func ExportServiceLogs(w http.ResponseWriter, r *http.Request) {
+	args, problem := journalArgs(r)
+	if problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+	service := r.URL.Query().Get("target")
+	args = append(args, "-o", "export")
+
+	// Tie journalctl to the request so it is killed when the client goes away
+	cmd := exec.CommandContext(r.Context(), "journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, "Error exporting logs", http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, "Error exporting logs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.fdo.journal")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+service+`.journal"`)
+	if _, err := io.Copy(w, stdout); err != nil {
+		components.LogRequest(r, "Error streaming journal export of %s: %v", service, err)
+	}
+	if err := cmd.Wait(); err != nil && r.Context().Err() == nil {
+		components.LogRequest(r, "journalctl export of %s failed: %v", service, err)
+	}
+}
+
+// Maximum number of clients following the error stream at once
+const maxErrorStreamSubscribers = 10
+
+var errorStreamSubscribers int32
+
+func StreamErrorLogs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	if atomic.AddInt32(&errorStreamSubscribers, 1) > maxErrorStreamSubscribers {
+		atomic.AddInt32(&errorStreamSubscribers, -1)
+		http.Error(w, "Too many error stream subscribers", http.StatusServiceUnavailable)
+		return
+	}
+	defer atomic.AddInt32(&errorStreamSubscribers, -1)
+
+	// journalctl is killed through the request context once the client disconnects
+	cmd := exec.CommandContext(r.Context(), "journalctl", "--user", "-p", "err", "-f", "-n", "0", "-o", "json", "--no-pager")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, "Error streaming logs", http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		http.Error(w, "Error streaming logs", http.StatusInternalServerError)
+		return
+	}
+	defer cmd.Wait()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "event: error\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,196 @@
+// routes/route_journal_test.go
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Puts a journalctl shell script running body first on PATH for the rest of
+// the test. The streams start journalctl themselves rather than through the
+// CommandRunner. Returns the file the script's arguments are
+// written to, one per line.
+func fakeJournalctl(t *testing.T, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > '" + argsFile + "'\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "journalctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+// journalctl -o json line of the entry with the given cursor
+func journalLine(cursor string) string {
+	return `{"__CURSOR":"` + cursor + `","__REALTIME_TIMESTAMP":"1700000000000000","_SYSTEMD_USER_UNIT":"app.service","MESSAGE":"entry ` + cursor + `"}` + "\n"
+}
+
+func TestServiceLogs(t *testing.T) {
+	const journalctl = "journalctl --user -u app.service --no-pager -o json"
+	tests := []struct {
+		name        string
+		query       string
+		line        string
+		stdout      string
+		wantCursors []string
+	}{
+		{"latest", "", journalctl + " -n 2", journalLine("s=1") + "not json\n" + journalLine("s=2"), []string{"s=1", "s=2"}},
+		{"after", "&after=s=1", journalctl + " --after-cursor=s=1 -n 2", journalLine("s=2") + journalLine("s=3"), []string{"s=2", "s=3"}},
+		{"before", "&before=s=3", journalctl + " --cursor=s=3 --reverse -n 3", journalLine("s=3") + journalLine("s=2") + journalLine("s=1"), []string{"s=1", "s=2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := useFakeRunner(t, map[string]fakeResult{tt.line: {stdout: tt.stdout}})
+
+			w := httptest.NewRecorder()
+			ServiceLogs(w, httptest.NewRequest("GET", "/system/services/logs?target=app.service&lines=2"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s (ran %q)", w.Code, w.Body, runner.calls)
+			}
+			var response struct {
+				Entries []LogEntry `json:"entries"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			cursors := []string{}
+			for _, entry := range response.Entries {
+				cursors = append(cursors, entry.Cursor)
+			}
+			if strings.Join(cursors, " ") != strings.Join(tt.wantCursors, " ") {
+				t.Errorf("cursors = %q, want %q", cursors, tt.wantCursors)
+			}
+		})
+	}
+}
+
+func TestServiceLogsTimeout(t *testing.T) {
+	useFakeRunner(t, map[string]fakeResult{
+		"journalctl --user -u app.service --no-pager -o json -n 100": {err: context.DeadlineExceeded},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	w := httptest.NewRecorder()
+	ServiceLogs(w, httptest.NewRequest("GET", "/system/services/logs?target=app.service", nil).WithContext(ctx))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusGatewayTimeout, w.Body)
+	}
+}
+
+// A field of the journal export format: NAME=value, or NAME alone on its line
+// for binary values that follow as a length-prefixed blob
+var exportFieldRe = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*(=.*)?$`)
+
+func TestExportServiceLogs(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantArgs   []string
+	}{
+		{"unit", "target=app.service", http.StatusOK, []string{"--user", "-u", "app.service", "-o", "export"}},
+		{"time range", "target=app.service&since=2024-01-01&until=today", http.StatusOK, []string{"--since=2024-01-01", "--until=today"}},
+		{"missing target", "", http.StatusBadRequest, nil},
+		{"invalid target", "target=../app.service", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argsFile := fakeJournalctl(t, `printf '__CURSOR=s=1;i=1\n__REALTIME_TIMESTAMP=1700000000000000\n_SYSTEMD_USER_UNIT=app.service\nMESSAGE=started\n\n'`)
+
+			w := httptest.NewRecorder()
+			ExportServiceLogs(w, httptest.NewRequest("GET", "/system/services/logs/export?"+tt.query, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if _, err := os.Stat(argsFile); err == nil {
+					t.Errorf("journalctl ran for a rejected request")
+				}
+				return
+			}
+
+			firstLine, _, _ := strings.Cut(w.Body.String(), "\n")
+			if !exportFieldRe.MatchString(firstLine) {
+				t.Errorf("export starts with %q, not an export field", firstLine)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="app.service.journal"` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+			args, err := os.ReadFile(argsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			joined := strings.Join(strings.Fields(string(args)), " ")
+			for _, arg := range tt.wantArgs {
+				if !strings.Contains(" "+joined+" ", " "+arg+" ") {
+					t.Errorf("journalctl args %q lack %q", joined, arg)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamErrorLogs(t *testing.T) {
+	argsFile := fakeJournalctl(t, `printf '%s\n' 'not json' '{"__CURSOR":"s=2","__REALTIME_TIMESTAMP":"1700000000000000","PRIORITY":"3","_SYSTEMD_USER_UNIT":"app.service","MESSAGE":"disk full"}'`)
+
+	w := httptest.NewRecorder()
+	StreamErrorLogs(w, httptest.NewRequest("GET", "/system/logs/errors/stream", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if len(events) != 1 {
+		t.Fatalf("events = %q, want one error event", events)
+	}
+	event, data, found := strings.Cut(events[0], "\ndata: ")
+	if !found || event != "event: error" {
+		t.Fatalf("event = %q", events[0])
+	}
+	var entry LogEntry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Unit != "app.service" || entry.Message != "disk full" || entry.Priority != 3 {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined := strings.Join(strings.Fields(string(args)), " "); !strings.Contains(joined, "-p err -f") {
+		t.Errorf("journalctl args = %q, want -p err -f", joined)
+	}
+}
+
+func TestStreamErrorLogsSubscriberCap(t *testing.T) {
+	argsFile := fakeJournalctl(t, "")
+	atomic.StoreInt32(&errorStreamSubscribers, maxErrorStreamSubscribers)
+	defer atomic.StoreInt32(&errorStreamSubscribers, 0)
+
+	w := httptest.NewRecorder()
+	StreamErrorLogs(w, httptest.NewRequest("GET", "/system/logs/errors/stream", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if _, err := os.Stat(argsFile); err == nil {
+		t.Errorf("journalctl ran beyond the subscriber cap")
+	}
+}
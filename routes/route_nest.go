@@ -2,12 +2,13 @@ package routes
 
 import (
     "encoding/json"
-    "log"
     "net/http"
     "os/exec"
     "strings"
 
     "github.com/gorilla/mux"
+
+    "napi/components"
 )
 
 type SystemUsage struct {
@@ -30,18 +31,18 @@ func executeCommandN(command string, args ...string) (string, error) {
 }
 
 func NestResourcesHandler(w http.ResponseWriter, r *http.Request) {
-    log.Println("Executing command: nest resources")
+    components.LogRequest(r, "Executing command: nest resources")
     output, err := executeCommandN("sh", "-c", "nest resources")
     if err != nil {
-        log.Printf("Failed to execute command: %v", err)
+        components.LogRequest(r, "Failed to execute command: %v", err)
         http.Error(w, "Failed to execute command: "+err.Error(), http.StatusInternalServerError)
         return
     }
 
-    log.Printf("Command output: %s", output)
+    components.LogRequest(r, "Command output: %s", output)
     lines := strings.Split(strings.TrimSpace(output), "\n")
     if len(lines) < 2 {
-        log.Println("Unexpected command output")
+        components.LogRequest(r, "Unexpected command output")
         http.Error(w, "Unexpected command output", http.StatusInternalServerError)
         return
     }
@@ -49,7 +50,7 @@ func NestResourcesHandler(w http.ResponseWriter, r *http.Request) {
     // Parse Disk usage line
     diskLineParts := strings.Fields(lines[0])
     if len(diskLineParts) < 8 {
-        log.Println("Unexpected disk usage format")
+        components.LogRequest(r, "Unexpected disk usage format")
         http.Error(w, "Unexpected disk usage format", http.StatusInternalServerError)
         return
     }
@@ -59,7 +60,7 @@ func NestResourcesHandler(w http.ResponseWriter, r *http.Request) {
     // Parse Memory usage line
     memoryLineParts := strings.Fields(lines[1])
     if len(memoryLineParts) < 8 {
-        log.Println("Unexpected memory usage format")
+        components.LogRequest(r, "Unexpected memory usage format")
         http.Error(w, "Unexpected memory usage format", http.StatusInternalServerError)
         return
     }
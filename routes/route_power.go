@@ -0,0 +1,148 @@
+// routes/route_power.go
+
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os/user"
+	"sync"
+	"time"
+
+	"napi/components"
+)
+
+// Time a confirm token from /power stays valid
+const powerConfirmTTL = 60 * time.Second
+
+// Whether power actions are enabled, ALLOW_POWER=true
+var allowPower bool
+
+// Message for each power action once it has been handed to the host
+var powerActions = map[string]string{
+	"logout":   "Logout of the server's user requested",
+	"reboot":   "Reboot requested",
+	"poweroff": "Power off requested",
+}
+
+type powerConfirmation struct {
+	action  string
+	user    string
+	expires time.Time
+}
+
+// Outstanding confirm tokens, each usable once
+var (
+	powerConfirmations   = map[string]powerConfirmation{}
+	powerConfirmationsMu sync.Mutex
+)
+
+// Issues a confirm token for action by user, dropping expired ones
+func issuePowerConfirmation(action, user string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	powerConfirmationsMu.Lock()
+	defer powerConfirmationsMu.Unlock()
+	now := time.Now()
+	for existing, confirmation := range powerConfirmations {
+		if now.After(confirmation.expires) {
+			delete(powerConfirmations, existing)
+		}
+	}
+	powerConfirmations[token] = powerConfirmation{action: action, user: user, expires: now.Add(powerConfirmTTL)}
+	return token, nil
+}
+
+// Consumes token, reporting whether it was issued to user for action and has
+// not expired
+func redeemPowerConfirmation(token, action, user string) bool {
+	powerConfirmationsMu.Lock()
+	defer powerConfirmationsMu.Unlock()
+	for existing, confirmation := range powerConfirmations {
+		if subtle.ConstantTimeCompare([]byte(existing), []byte(token)) != 1 {
+			continue
+		}
+		delete(powerConfirmations, existing)
+		return confirmation.action == action && confirmation.user == user && time.Now().Before(confirmation.expires)
+	}
+	return false
+}
+
+// Runs the command behind a power action, returning its trimmed stderr
+func runPowerAction(ctx context.Context, action string) (string, error) {
+	if action == "logout" {
+		current, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		_, stderr, err := runCommand(ctx, "loginctl", "terminate-user", current.Username)
+		return stderr, err
+	}
+	_, stderr, err := runCommand(ctx, "systemctl", action)
+	return stderr, err
+}
+
+func PowerAction(w http.ResponseWriter, r *http.Request) {
+	if !allowPower {
+		http.Error(w, "Power actions are disabled", http.StatusForbidden)
+		return
+	}
+	if role, _ := r.Context().Value("role").(string); role != "admin" {
+		http.Error(w, "Power actions require the admin role", http.StatusForbidden)
+		return
+	}
+	action := r.URL.Query().Get("action")
+	message, ok := powerActions[action]
+	if !ok {
+		http.Error(w, "action must be logout, reboot or poweroff", http.StatusBadRequest)
+		return
+	}
+	username, _ := r.Context().Value("user").(string)
+
+	// A request without confirm only returns a token; the action runs when a
+	// second request sends it back within powerConfirmTTL
+	confirm := r.URL.Query().Get("confirm")
+	if confirm == "" {
+		token, err := issuePowerConfirmation(action, username)
+		if err != nil {
+			http.Error(w, "Error creating confirm token", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":    "Repeat the request with this confirm token to " + action,
+			"action":     action,
+			"confirm":    token,
+			"expires_in": int(powerConfirmTTL.Seconds()),
+		})
+		return
+	}
+	if !redeemPowerConfirmation(confirm, action, username) {
+		http.Error(w, "Invalid or expired confirm token", http.StatusForbidden)
+		return
+	}
+
+	components.LogRequest(r, "User %s requested power action %s", username, action)
+	if stderr, err := runPowerAction(r.Context(), action); err != nil {
+		if err == errCommandTimeout {
+			writeTimeoutError(w, "Timed out requesting "+action)
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Error requesting "+action, stderr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": message,
+	})
+}
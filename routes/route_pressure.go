@@ -0,0 +1,185 @@
+// routes/route_pressure.go
+
+package routes
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var pressureResources = []string{"cpu", "memory", "io"}
+
+type PressureStall struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+type Pressure struct {
+	Some *PressureStall `json:"some"`
+	Full *PressureStall `json:"full"`
+}
+
+// Parses the contents of a PSI file such as /proc/pressure/memory:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePressure(data string) Pressure {
+	pressure := Pressure{}
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		stall := &PressureStall{}
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+			switch key {
+			case "avg10":
+				stall.Avg10, _ = strconv.ParseFloat(value, 64)
+			case "avg60":
+				stall.Avg60, _ = strconv.ParseFloat(value, 64)
+			case "avg300":
+				stall.Avg300, _ = strconv.ParseFloat(value, 64)
+			case "total":
+				stall.Total, _ = strconv.ParseUint(value, 10, 64)
+			}
+		}
+		switch fields[0] {
+		case "some":
+			pressure.Some = stall
+		case "full":
+			pressure.Full = stall
+		}
+	}
+	return pressure
+}
+
+// Reads <dir>/<prefix><resource><suffix> for every PSI resource, skipping
+// resources the kernel does not expose
+func readPressureFiles(dir, suffix string) map[string]Pressure {
+	result := map[string]Pressure{}
+	for _, resource := range pressureResources {
+		data, err := os.ReadFile(filepath.Join(dir, resource+suffix))
+		if err != nil {
+			continue
+		}
+		result[resource] = parsePressure(string(data))
+	}
+	return result
+}
+
+func SystemPressure(w http.ResponseWriter, r *http.Request) {
+	pressure := readPressureFiles("/proc/pressure", "")
+	if len(pressure) == 0 {
+		http.Error(w, "Pressure stall information is not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pressure": pressure,
+	})
+}
+
+func ServicePressure(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	properties, err := showUnitProperties(r.Context(), scopeFlag, service, "ControlGroup")
+	if err != nil {
+		writeUnitReadError(w, "Error reading control group of "+service, err)
+		return
+	}
+	cgroup := properties["ControlGroup"]
+	if cgroup == "" {
+		http.Error(w, "Service "+service+" has no control group", http.StatusNotFound)
+		return
+	}
+
+	pressure := readPressureFiles(filepath.Join("/sys/fs/cgroup", cgroup), ".pressure")
+	if len(pressure) == 0 {
+		http.Error(w, "Pressure stall information is not available for "+service, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":     service,
+		"cgroup":   cgroup,
+		"pressure": pressure,
+	})
+}
+
+// Accounting properties reported by /services/resources
+var resourceProperties = []string{"MemoryCurrent", "CPUUsageNSec", "TasksCurrent", "IPIngressBytes", "IPEgressBytes"}
+
+// Parses an accounting property, returning nil when systemd reports it as
+// unavailable: "[not set]", empty, or the all-ones "infinity" value
+func accountingValue(value string) *uint64 {
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || parsed == math.MaxUint64 {
+		return nil
+	}
+	return &parsed
+}
+
+func ServiceResources(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	properties, err := showUnitProperties(r.Context(), scopeFlag, service, append([]string{"LoadState", "ActiveState"}, resourceProperties...)...)
+	if err != nil {
+		writeUnitReadError(w, "Error reading resource usage of "+service, err)
+		return
+	}
+	if properties["LoadState"] == "not-found" {
+		http.Error(w, "Service "+service+" not found", http.StatusNotFound)
+		return
+	}
+
+	resources := map[string]*uint64{}
+	for _, property := range resourceProperties {
+		resources[property] = accountingValue(properties[property])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":        service,
+		"ActiveState": properties["ActiveState"],
+		"resources":   resources,
+	})
+}
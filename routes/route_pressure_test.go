@@ -0,0 +1,79 @@
+// routes/route_pressure_test.go
+
+package routes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePressure(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantSome *PressureStall
+		wantFull *PressureStall
+	}{
+		{
+			name:     "some and full",
+			data:     "some avg10=1.50 avg60=0.75 avg300=0.20 total=123456\nfull avg10=0.50 avg60=0.25 avg300=0.05 total=4567\n",
+			wantSome: &PressureStall{Avg10: 1.5, Avg60: 0.75, Avg300: 0.2, Total: 123456},
+			wantFull: &PressureStall{Avg10: 0.5, Avg60: 0.25, Avg300: 0.05, Total: 4567},
+		},
+		{
+			// The system-wide cpu file has no full line on older kernels
+			name:     "cpu without full",
+			data:     "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+			wantSome: &PressureStall{},
+		},
+		{
+			name:     "unknown and malformed fields",
+			data:     "some avg10=2.00 avg60=bad weird total=9 extra=1\nother avg10=5.00\n",
+			wantSome: &PressureStall{Avg10: 2, Total: 9},
+		},
+		{
+			name: "empty",
+			data: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePressure(tt.data)
+			if !equalStall(got.Some, tt.wantSome) {
+				t.Errorf("some = %+v, want %+v", got.Some, tt.wantSome)
+			}
+			if !equalStall(got.Full, tt.wantFull) {
+				t.Errorf("full = %+v, want %+v", got.Full, tt.wantFull)
+			}
+		})
+	}
+}
+
+func equalStall(a, b *PressureStall) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestReadPressureFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "memory.pressure"), []byte("some avg10=3.00 avg60=2.00 avg300=1.00 total=10\nfull avg10=1.00 avg60=0.50 avg300=0.25 total=5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "io.pressure"), []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readPressureFiles(dir, ".pressure")
+	if len(got) != 2 {
+		t.Fatalf("readPressureFiles() = %+v, want memory and io only", got)
+	}
+	if memory := got["memory"]; memory.Full == nil || memory.Full.Total != 5 {
+		t.Errorf("memory = %+v", memory)
+	}
+	if _, ok := got["cpu"]; ok {
+		t.Errorf("cpu reported without a cpu.pressure file")
+	}
+}
@@ -0,0 +1,60 @@
+// routes/route_runner.go
+
+package routes
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// CommandRunner runs the external programs behind the handlers. Run returns
+// the program's stdout and stderr even when it fails; a non-zero exit is
+// reported with an error that has an ExitCode() int method, as
+// *exec.ExitError does. The process must be stopped once ctx is done.
+//
+// The streams read journalctl's output as it is written and start it through
+// os/exec directly: ServiceLogs with follow=true, StreamErrorLogs and
+// ExportServiceLogs. One-shot journal reads go through the runner like the
+// systemd commands do.
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) (stdout, stderr []byte, err error)
+}
+
+// ExecRunner is the CommandRunner that runs programs with os/exec
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+var commandRunner CommandRunner = ExecRunner{}
+
+// SetCommandRunner replaces the runner used by the handlers and returns the
+// previous one, so tests can substitute canned output and restore it after
+func SetCommandRunner(runner CommandRunner) CommandRunner {
+	previous := commandRunner
+	commandRunner = runner
+	return previous
+}
+
+// Runs name through commandRunner, returning stdout and trimmed stderr.
+// A run cut off by ctx's deadline fails with errCommandTimeout.
+func runCommand(ctx context.Context, name string, args ...string) (string, string, error) {
+	stdout, stderr, err := commandRunner.Run(ctx, name, args...)
+	return string(stdout), strings.TrimSpace(string(stderr)), commandError(ctx, err)
+}
+
+// Reports the exit status of a command that ran and exited non-zero
+func exitCode(err error) (int, bool) {
+	if exited, ok := err.(interface{ ExitCode() int }); ok {
+		return exited.ExitCode(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,128 @@
+// routes/route_runner_test.go
+
+package routes
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Canned result of one command run by fakeRunner
+type fakeResult struct {
+	stdout string
+	stderr string
+	err    error
+}
+
+// Error of a command that exited with the given status, like *exec.ExitError
+type fakeExitError int
+
+func (e fakeExitError) Error() string { return "exit status " + strconv.Itoa(int(e)) }
+func (e fakeExitError) ExitCode() int { return int(e) }
+
+// CommandRunner answering commands from a table keyed by the command line,
+// recording every call. Commands missing from the table exit with status 1.
+type fakeRunner struct {
+	mu      sync.Mutex
+	results map[string]fakeResult
+	calls   []string
+}
+
+func (f *fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.mu.Lock()
+	f.calls = append(f.calls, line)
+	result, ok := f.results[line]
+	f.mu.Unlock()
+	if !ok {
+		return nil, []byte("unexpected command"), fakeExitError(1)
+	}
+	if result.err == context.DeadlineExceeded {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	return []byte(result.stdout), []byte(result.stderr), result.err
+}
+
+func (f *fakeRunner) ran(line string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, call := range f.calls {
+		if call == line {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeRunner that answers line with each of outputs in turn, for commands
+// whose output changes between calls
+type sequenceRunner struct {
+	fakeRunner
+	line    string
+	outputs []string
+}
+
+func (s *sequenceRunner) Run(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	if strings.Join(append([]string{name}, args...), " ") == s.line && len(s.outputs) > 0 {
+		output := s.outputs[0]
+		s.outputs = s.outputs[1:]
+		return []byte(output), nil, nil
+	}
+	return s.fakeRunner.Run(ctx, name, args...)
+}
+
+// Installs a fakeRunner with results for the rest of the test
+func useFakeRunner(t *testing.T, results map[string]fakeResult) *fakeRunner {
+	t.Helper()
+	runner := &fakeRunner{results: results}
+	previous := SetCommandRunner(runner)
+	t.Cleanup(func() { SetCommandRunner(previous) })
+	return runner
+}
+
+func TestRunCommand(t *testing.T) {
+	useFakeRunner(t, map[string]fakeResult{
+		"systemctl --user is-active cron.service": {stdout: "active\n"},
+		"systemctl --user start missing.service":  {stderr: "  Unit missing.service not found.\n", err: fakeExitError(5)},
+		"sleep":                                   {err: context.DeadlineExceeded},
+	})
+
+	tests := []struct {
+		name       string
+		command    []string
+		timeout    time.Duration
+		wantStdout string
+		wantStderr string
+		wantCode   int
+		wantErr    error
+	}{
+		{"success", []string{"systemctl", "--user", "is-active", "cron.service"}, 0, "active\n", "", 0, nil},
+		{"exit status and trimmed stderr", []string{"systemctl", "--user", "start", "missing.service"}, 0, "", "Unit missing.service not found.", 5, nil},
+		{"timeout", []string{"sleep"}, time.Millisecond, "", "", 0, errCommandTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			stdout, stderr, err := runCommand(ctx, tt.command[0], tt.command[1:]...)
+			if stdout != tt.wantStdout || stderr != tt.wantStderr {
+				t.Errorf("runCommand() = %q, %q, want %q, %q", stdout, stderr, tt.wantStdout, tt.wantStderr)
+			}
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Errorf("runCommand() error = %v, want %v", err, tt.wantErr)
+			}
+			if code, _ := exitCode(err); code != tt.wantCode {
+				t.Errorf("exitCode() = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
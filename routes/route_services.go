@@ -0,0 +1,1481 @@
+// routes/route_services.go
+
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"napi/components"
+)
+
+// Maximum number of units a single pattern request may act on
+const maxPatternUnits = 50
+
+var unitPatternRe = regexp.MustCompile(`^[A-Za-z0-9@:._\-\\*?\[\]]+$`)
+
+// Checks that a unit glob pattern is well-formed and safe to pass to systemctl
+func validateUnitPattern(pattern string) bool {
+	if pattern == "" || len(pattern) > 256 || !unitPatternRe.MatchString(pattern) {
+		return false
+	}
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// Returns the units in the failed state whose name matches the glob pattern
+func failedUnitsMatching(ctx context.Context, scopeFlag, pattern string) ([]Unit, error) {
+	stdout, err := commandOutput(ctx, "systemctl", scopeFlag, "list-units", "--all", "--plain", "--no-legend")
+	if err != nil {
+		return nil, err
+	}
+	units, err := parseUnits(stdout, ".")
+	if err != nil {
+		return nil, err
+	}
+	return matchFailedUnits(units, pattern), nil
+}
+
+// Picks out the failed units whose name matches the glob pattern
+func matchFailedUnits(units []Unit, pattern string) []Unit {
+	matched := []Unit{}
+	for _, unit := range units {
+		if unit.ACTIVE != "failed" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, unit.UNIT); ok {
+			matched = append(matched, unit)
+		}
+	}
+	return matched
+}
+
+func ResetFailedPattern(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Pattern string `json:"pattern"`
+	}
+	if !decodeJSONBody(w, r, &request) {
+		return
+	}
+	if !validateUnitPattern(request.Pattern) {
+		http.Error(w, "Invalid unit pattern", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	units, err := failedUnitsMatching(r.Context(), scopeFlag, request.Pattern)
+	if err != nil {
+		writeUnitReadError(w, "Error fetching failed units", err)
+		return
+	}
+
+	truncated := false
+	if len(units) > maxPatternUnits {
+		units = units[:maxPatternUnits]
+		truncated = true
+	}
+
+	reset := []string{}
+	errors := map[string]string{}
+	for _, unit := range units {
+		if _, err := runSystemctlScopeContext(r.Context(), scopeFlag, "reset-failed", "--", unit.UNIT); err != nil {
+			errors[unit.UNIT] = err.Error()
+			continue
+		}
+		reset = append(reset, unit.UNIT)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pattern":   request.Pattern,
+		"reset":     reset,
+		"errors":    errors,
+		"truncated": truncated,
+	})
+}
+
+var unitNameRe = regexp.MustCompile(`^[A-Za-z0-9@:_\-\\][A-Za-z0-9@:._\-\\]*$`)
+
+// Checks that a unit name is safe to use in systemctl arguments and drop-in paths
+func validateUnitName(unit string) bool {
+	return len(unit) <= 256 && unitNameRe.MatchString(unit)
+}
+
+// Reads the requested properties of a unit via systemctl show
+func showUnitProperties(ctx context.Context, scopeFlag, unit string, properties ...string) (map[string]string, error) {
+	args := []string{scopeFlag, "show"}
+	for _, property := range properties {
+		args = append(args, "-p", property)
+	}
+	out, err := commandOutput(ctx, "systemctl", append(args, "--", unit)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseProperties(out), nil
+}
+
+// Answers a failed read of unit information with 504 when the command timed
+// out and 500 otherwise
+func writeUnitReadError(w http.ResponseWriter, message string, err error) {
+	if err == errCommandTimeout {
+		writeTimeoutError(w, message)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// Parses KEY=VALUE lines as printed by systemctl show
+func parseProperties(data string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(data, "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// Returns the directory holding drop-in overrides for a unit of the given
+// scope
+func dropInDir(scopeFlag, unit string) (string, error) {
+	if scopeFlag == "--system" {
+		return filepath.Join("/etc/systemd/system", unit+".d"), nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "systemd", "user", unit+".d"), nil
+}
+
+// Writes a drop-in override for a unit and reloads its manager
+func writeDropIn(ctx context.Context, scopeFlag, unit, name, content string) (string, error) {
+	dir, err := dropInDir(scopeFlag, unit)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dropInPath := filepath.Join(dir, name+".conf")
+	if err := os.WriteFile(dropInPath, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	if _, err := runSystemctlScopeContext(ctx, scopeFlag, "daemon-reload"); err != nil {
+		return "", err
+	}
+	return dropInPath, nil
+}
+
+var outputProperties = []string{"StandardOutput", "StandardError", "SyslogIdentifier"}
+
+var plainOutputTargets = map[string]bool{
+	"inherit":         true,
+	"null":            true,
+	"tty":             true,
+	"journal":         true,
+	"kmsg":            true,
+	"journal+console": true,
+	"kmsg+console":    true,
+	"socket":          true,
+}
+
+var syslogIdentifierRe = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// Checks a StandardOutput/StandardError value against the targets systemd accepts
+func validateOutputTarget(value string) bool {
+	if plainOutputTargets[value] {
+		return true
+	}
+	kind, target, found := strings.Cut(value, ":")
+	if !found || target == "" || strings.ContainsAny(target, "\n\r") {
+		return false
+	}
+	switch kind {
+	case "file", "append", "truncate":
+		return filepath.IsAbs(target)
+	case "fd":
+		return syslogIdentifierRe.MatchString(target)
+	}
+	return false
+}
+
+func GetOutputConfig(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	properties, err := showUnitProperties(r.Context(), scopeFlag, service, outputProperties...)
+	if err != nil {
+		writeUnitReadError(w, "Error reading output configuration of "+service, err)
+		return
+	}
+
+	config := map[string]string{}
+	for _, property := range outputProperties {
+		config[property] = properties[property]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":   service,
+		"config": config,
+	})
+}
+
+func SetOutputConfig(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	var request map[string]string
+	if !decodeJSONBody(w, r, &request) {
+		return
+	}
+
+	// Properties left out of the request keep the value the drop-in already
+	// has, so partial updates add up
+	dir, err := dropInDir(scopeFlag, service)
+	if err != nil {
+		writeUnitReadError(w, "Error updating output configuration of "+service, err)
+		return
+	}
+	existing := map[string]string{}
+	if data, err := os.ReadFile(filepath.Join(dir, "output.conf")); err == nil {
+		existing = parseProperties(string(data))
+	} else if !os.IsNotExist(err) {
+		writeUnitReadError(w, "Error reading output configuration of "+service, err)
+		return
+	}
+
+	var content strings.Builder
+	content.WriteString("[Service]\n")
+	set := 0
+	for _, property := range outputProperties {
+		value, ok := request[property]
+		if !ok {
+			if value, ok = existing[property]; ok {
+				content.WriteString(property + "=" + value + "\n")
+			}
+			continue
+		}
+		if property == "SyslogIdentifier" {
+			if !syslogIdentifierRe.MatchString(value) {
+				http.Error(w, "Invalid SyslogIdentifier "+value, http.StatusBadRequest)
+				return
+			}
+		} else if !validateOutputTarget(value) {
+			http.Error(w, "Invalid "+property+" target "+value, http.StatusBadRequest)
+			return
+		}
+		content.WriteString(property + "=" + value + "\n")
+		set++
+	}
+	if set == 0 {
+		http.Error(w, "At least one of StandardOutput, StandardError or SyslogIdentifier is required", http.StatusBadRequest)
+		return
+	}
+
+	dropInPath, err := writeDropIn(r.Context(), scopeFlag, service, "output", content.String())
+	if err != nil {
+		writeUnitReadError(w, "Error updating output configuration of "+service, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Output configuration of " + service + " updated successfully",
+		"dropin":  dropInPath,
+	})
+}
+
+// Last known configuration checksum per unit, used when the client does not
+// supply one to restart-if-changed. Kept in memory only, so the first call
+// for a unit after the server starts records its checksum.
+var (
+	unitChecksumsMu sync.Mutex
+	unitChecksums   = map[string]string{}
+)
+
+// Computes a SHA-256 over the unit's fragment and drop-in files, in the order
+// systemd reports them
+func unitConfigChecksum(ctx context.Context, scopeFlag, unit string) (string, []string, error) {
+	properties, err := showUnitProperties(ctx, scopeFlag, unit, "FragmentPath", "DropInPaths")
+	if err != nil {
+		return "", nil, err
+	}
+	paths := []string{}
+	if fragment := properties["FragmentPath"]; fragment != "" {
+		paths = append(paths, fragment)
+	}
+	paths = append(paths, strings.Fields(properties["DropInPaths"])...)
+	if len(paths) == 0 {
+		return "", nil, os.ErrNotExist
+	}
+
+	hash := sha256.New()
+	for _, configPath := range paths {
+		content, err := os.ReadFile(configPath)
+		if err != nil {
+			return "", nil, err
+		}
+		hash.Write([]byte(configPath + "\x00"))
+		hash.Write(content)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), paths, nil
+}
+
+func RestartIfChanged(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	checksum, paths, err := unitConfigChecksum(r.Context(), scopeFlag, service)
+	if err != nil {
+		writeUnitReadError(w, "Error computing configuration checksum of "+service, err)
+		return
+	}
+
+	previous := r.URL.Query().Get("checksum")
+	unitChecksumsMu.Lock()
+	if previous == "" {
+		previous = unitChecksums[scopeFlag+" "+service]
+	}
+	unitChecksumsMu.Unlock()
+
+	// The first sighting of a unit only records its checksum: after a server
+	// restart every unit would otherwise be restarted once, changed or not
+	restarted, reason := false, ""
+	if previous == "" {
+		reason = "No previous checksum known for " + service + "; recorded the current one without restarting"
+	} else if previous != checksum {
+		if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, "restart", "--", service); err != nil {
+			writeSystemctlError(w, "Error restarting service "+service, stderr, err)
+			return
+		}
+		restarted = true
+	}
+
+	unitChecksumsMu.Lock()
+	unitChecksums[scopeFlag+" "+service] = checksum
+	unitChecksumsMu.Unlock()
+
+	response := map[string]interface{}{
+		"unit":      service,
+		"checksum":  checksum,
+		"previous":  previous,
+		"files":     paths,
+		"restarted": restarted,
+	}
+	if reason != "" {
+		response["reason"] = reason
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Linux signal names indexed by number, for reporting units killed by a signal
+var signalNames = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT",
+	7: "SIGBUS", 8: "SIGFPE", 9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2",
+	13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM", 16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT",
+	19: "SIGSTOP", 20: "SIGTSTP", 21: "SIGTTIN", 22: "SIGTTOU", 23: "SIGURG", 24: "SIGXCPU",
+	25: "SIGXFSZ", 26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO", 30: "SIGPWR",
+	31: "SIGSYS",
+}
+
+// ExecMainCode carries the si_code of the main process: CLD_EXITED,
+// CLD_KILLED or CLD_DUMPED
+var execMainCodes = map[string]string{
+	"0": "",
+	"1": "exited",
+	"2": "killed",
+	"3": "dumped",
+}
+
+type ExitInfo struct {
+	Unit      string  `json:"unit"`
+	Result    string  `json:"result"`
+	Code      string  `json:"code"`
+	Status    int     `json:"status"`
+	Signal    *string `json:"signal"`
+	NRestarts int     `json:"restarts"`
+}
+
+// Builds the exit summary of a unit from its systemctl show properties
+func parseExitInfo(unit string, properties map[string]string) ExitInfo {
+	info := ExitInfo{
+		Unit:   unit,
+		Result: properties["Result"],
+		Code:   properties["ExecMainCode"],
+	}
+	if code, ok := execMainCodes[info.Code]; ok {
+		info.Code = code
+	}
+	info.Status, _ = strconv.Atoi(properties["ExecMainStatus"])
+	info.NRestarts, _ = strconv.Atoi(properties["NRestarts"])
+	if info.Code == "killed" || info.Code == "dumped" {
+		if name, ok := signalNames[info.Status]; ok {
+			info.Signal = &name
+		}
+	}
+	return info
+}
+
+func ServiceExitInfo(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	properties, err := showUnitProperties(r.Context(), scopeFlag, service, "ExecMainStatus", "ExecMainCode", "Result", "NRestarts")
+	if err != nil {
+		writeUnitReadError(w, "Error reading exit information of "+service, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(parseExitInfo(service, properties))
+}
+
+// Parses a timestamp as printed by systemctl show, e.g.
+// "Mon 2024-07-01 12:00:00 UTC". Empty and "n/a" values are reported as unset.
+func parseSystemdTimestamp(value string) (time.Time, bool) {
+	if value == "" || value == "n/a" {
+		return time.Time{}, false
+	}
+	if strings.HasPrefix(value, "@") {
+		seconds, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(seconds, 0), true
+	}
+	parsed, err := time.ParseInLocation("Mon 2006-01-02 15:04:05 MST", value, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parsed, true
+}
+
+type TransitioningUnit struct {
+	Unit
+	Since    string  `json:"since,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+}
+
+// Picks out the units in a transition and how long each has been in it. An
+// activating unit entered that state when it left inactive, a deactivating
+// one when it left active.
+func transitioningUnits(units []Unit, properties map[string]map[string]string, now time.Time) []TransitioningUnit {
+	result := []TransitioningUnit{}
+	for _, unit := range units {
+		var timestampProperty string
+		switch unit.ACTIVE {
+		case "activating":
+			timestampProperty = "InactiveExitTimestamp"
+		case "deactivating":
+			timestampProperty = "ActiveExitTimestamp"
+		default:
+			continue
+		}
+		entry := TransitioningUnit{Unit: unit}
+		if since, ok := parseSystemdTimestamp(properties[unit.UNIT][timestampProperty]); ok {
+			entry.Since = since.UTC().Format(time.RFC3339)
+			entry.Duration = now.Sub(since).Seconds()
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TransitioningServices(w http.ResponseWriter, r *http.Request) {
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	stdout, err := commandOutput(r.Context(), "systemctl", scopeFlag, "list-units", "--all", "--plain", "--no-legend")
+	if err != nil {
+		writeUnitReadError(w, "Error fetching units", err)
+		return
+	}
+	units, err := parseUnits(stdout, ".")
+	if err != nil {
+		http.Error(w, "Error parsing units output", http.StatusInternalServerError)
+		return
+	}
+
+	properties := map[string]map[string]string{}
+	for _, unit := range units {
+		if unit.ACTIVE != "activating" && unit.ACTIVE != "deactivating" {
+			continue
+		}
+		unitProperties, err := showUnitProperties(r.Context(), scopeFlag, unit.UNIT, "InactiveExitTimestamp", "ActiveExitTimestamp")
+		if err == errCommandTimeout {
+			writeUnitReadError(w, "Error fetching transition times", err)
+			return
+		}
+		if err != nil {
+			continue
+		}
+		properties[unit.UNIT] = unitProperties
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"units": transitioningUnits(units, properties, time.Now()),
+	})
+}
+
+type UnitState struct {
+	Unit          string `json:"unit"`
+	UnitFileState string `json:"UnitFileState"`
+	LoadState     string `json:"LoadState"`
+}
+
+func readUnitState(ctx context.Context, scopeFlag, unit string) (UnitState, error) {
+	properties, err := showUnitProperties(ctx, scopeFlag, unit, "UnitFileState", "LoadState")
+	if err != nil {
+		return UnitState{}, err
+	}
+	return UnitState{
+		Unit:          unit,
+		UnitFileState: properties["UnitFileState"],
+		LoadState:     properties["LoadState"],
+	}, nil
+}
+
+func isMaskedState(state UnitState) bool {
+	return strings.HasPrefix(state.UnitFileState, "masked") || state.LoadState == "masked"
+}
+
+// Returns the systemctl subcommands needed to move a unit from its current
+// state to the desired one, in order; nothing when it is already there
+func unitStateActions(current UnitState, desired string) []string {
+	masked := isMaskedState(current)
+	switch desired {
+	case "enabled":
+		actions := []string{}
+		if masked {
+			actions = append(actions, "unmask")
+		}
+		if masked || current.UnitFileState != "enabled" {
+			actions = append(actions, "enable")
+		}
+		return actions
+	case "disabled":
+		actions := []string{}
+		if masked {
+			actions = append(actions, "unmask")
+		}
+		if current.UnitFileState == "enabled" || current.UnitFileState == "enabled-runtime" {
+			actions = append(actions, "disable")
+		}
+		return actions
+	case "masked":
+		if !masked {
+			return []string{"mask"}
+		}
+	case "unmasked":
+		if masked {
+			return []string{"unmask"}
+		}
+	}
+	return []string{}
+}
+
+var desiredUnitStates = map[string]bool{"enabled": true, "disabled": true, "masked": true, "unmasked": true}
+
+func GetUnitState(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	state, err := readUnitState(r.Context(), scopeFlag, service)
+	if err != nil {
+		writeUnitReadError(w, "Error reading state of "+service, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+func SetUnitState(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	desired := r.URL.Query().Get("state")
+	if service == "" || desired == "" {
+		http.Error(w, "Service name and state are required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	if !desiredUnitStates[desired] {
+		http.Error(w, "state must be one of enabled, disabled, masked or unmasked", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	current, err := readUnitState(r.Context(), scopeFlag, service)
+	if err != nil {
+		writeUnitReadError(w, "Error reading state of "+service, err)
+		return
+	}
+
+	actions := unitStateActions(current, desired)
+	for _, action := range actions {
+		if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, action, "--", service); err != nil {
+			writeSystemctlError(w, "Error running "+action+" on "+service, stderr, err)
+			return
+		}
+	}
+
+	state := current
+	if len(actions) > 0 {
+		state, err = readUnitState(r.Context(), scopeFlag, service)
+		if err != nil {
+			writeUnitReadError(w, "Error reading state of "+service, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":    service,
+		"actions": actions,
+		"changed": len(actions) > 0,
+		"state":   state,
+	})
+}
+
+// Masks or unmasks a unit, skipping systemctl when it is already in the
+// requested state, and answers with the resulting unit file state
+func setUnitMask(w http.ResponseWriter, r *http.Request, mask bool) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+	action, event := "unmask", "unmasked"
+	if mask {
+		action, event = "mask", "masked"
+	}
+
+	current, err := readUnitState(r.Context(), scopeFlag, service)
+	if err != nil {
+		writeUnitReadError(w, "Error reading state of "+service, err)
+		return
+	}
+	changed := isMaskedState(current) != mask
+	if changed {
+		if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, action, "--", service); err != nil {
+			writeSystemctlError(w, "Error running "+action+" on "+service, stderr, err)
+			return
+		}
+		notifyServiceAction(r, event, service, scopeFlag)
+	}
+
+	properties, err := showUnitProperties(r.Context(), scopeFlag, service, "UnitFileState", "LoadState", "ActiveState")
+	if err != nil {
+		writeUnitReadError(w, "Error reading state of "+service, err)
+		return
+	}
+	response := map[string]interface{}{
+		"unit":    service,
+		"changed": changed,
+		"state": UnitState{
+			Unit:          service,
+			UnitFileState: properties["UnitFileState"],
+			LoadState:     properties["LoadState"],
+		},
+		"active_state": properties["ActiveState"],
+	}
+	// Masking only prevents future starts; a running unit keeps running
+	switch properties["ActiveState"] {
+	case "active", "activating", "reloading":
+		if mask {
+			response["warning"] = "Unit " + service + " is masked but still running until it is stopped"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func MaskService(w http.ResponseWriter, r *http.Request) {
+	setUnitMask(w, r, true)
+}
+
+func UnmaskService(w http.ResponseWriter, r *http.Request) {
+	setUnitMask(w, r, false)
+}
+
+type UnitActionResult struct {
+	Unit    string `json:"unit"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+func RestartFailedServices(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+	if !validateUnitPattern(pattern) {
+		http.Error(w, "Invalid unit pattern", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	units, err := failedUnitsMatching(r.Context(), scopeFlag, pattern)
+	if err != nil {
+		writeUnitReadError(w, "Error fetching failed units", err)
+		return
+	}
+
+	truncated := false
+	if len(units) > maxPatternUnits {
+		units = units[:maxPatternUnits]
+		truncated = true
+	}
+
+	results := []UnitActionResult{}
+	restarted := 0
+	for _, unit := range units {
+		result := UnitActionResult{Unit: unit.UNIT, Success: true}
+		if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, "restart", "--", unit.UNIT); err != nil {
+			result.Success = false
+			result.Error = stderr
+			if result.Error == "" {
+				result.Error = err.Error()
+			}
+		} else {
+			restarted++
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pattern":   pattern,
+		"results":   results,
+		"restarted": restarted,
+		"failed":    len(results) - restarted,
+		"truncated": truncated,
+	})
+}
+
+const (
+	defaultDependencyDepth = 3
+	maxDependencyDepth     = 10
+)
+
+type DependencyNode struct {
+	Unit         string            `json:"unit"`
+	Dependencies []*DependencyNode `json:"dependencies,omitempty"`
+}
+
+// Characters systemctl uses to draw the dependency tree and unit states
+const dependencyTreeChars = " │├└─●○×*"
+
+// Parses systemctl list-dependencies output into a tree. Nesting is derived
+// from the column at which each unit name starts, so both the drawn tree and
+// --plain output are understood. Nodes deeper than maxDepth are dropped.
+func parseDependencies(data string, maxDepth int) *DependencyNode {
+	type level struct {
+		column int
+		node   *DependencyNode
+	}
+	var root *DependencyNode
+	stack := []level{}
+	for _, line := range strings.Split(data, "\n") {
+		name := strings.TrimLeft(line, dependencyTreeChars)
+		if name == "" {
+			continue
+		}
+		column := len([]rune(line)) - len([]rune(name))
+		node := &DependencyNode{Unit: strings.TrimSpace(name)}
+		if root == nil {
+			root = node
+			stack = append(stack, level{column: column, node: node})
+			continue
+		}
+		for len(stack) > 1 && stack[len(stack)-1].column >= column {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > maxDepth {
+			continue
+		}
+		parent := stack[len(stack)-1].node
+		parent.Dependencies = append(parent.Dependencies, node)
+		stack = append(stack, level{column: column, node: node})
+	}
+	return root
+}
+
+func ServiceDependencies(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	depth := defaultDependencyDepth
+	if value := r.URL.Query().Get("depth"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDependencyDepth {
+			http.Error(w, "depth must be between 1 and "+strconv.Itoa(maxDependencyDepth), http.StatusBadRequest)
+			return
+		}
+		depth = parsed
+	}
+
+	args := []string{scopeFlag, "list-dependencies", "--no-pager"}
+	reverse := r.URL.Query().Get("reverse") == "true"
+	if reverse {
+		args = append(args, "--reverse")
+	}
+	out, err := commandOutput(r.Context(), "systemctl", append(args, "--", service)...)
+	if err != nil {
+		writeUnitReadError(w, "Error fetching dependencies of "+service, err)
+		return
+	}
+
+	tree := parseDependencies(out, depth)
+	if tree == nil {
+		http.Error(w, "No dependency information for "+service, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":    service,
+		"reverse": reverse,
+		"depth":   depth,
+		"tree":    tree,
+	})
+}
+
+type ManagerSummary struct {
+	State         string `json:"state"`
+	Units         int    `json:"units"`
+	Loaded        int    `json:"loaded"`
+	Active        int    `json:"active"`
+	Inactive      int    `json:"inactive"`
+	Failed        int    `json:"failed"`
+	Transitioning int    `json:"transitioning"`
+}
+
+// Tallies units by load and active state
+func summarizeUnits(state string, units []Unit) ManagerSummary {
+	summary := ManagerSummary{State: state, Units: len(units)}
+	for _, unit := range units {
+		if unit.LOAD == "loaded" {
+			summary.Loaded++
+		}
+		switch unit.ACTIVE {
+		case "active", "reloading":
+			summary.Active++
+		case "inactive":
+			summary.Inactive++
+		case "failed":
+			summary.Failed++
+		case "activating", "deactivating":
+			summary.Transitioning++
+		}
+	}
+	return summary
+}
+
+func SystemSummary(w http.ResponseWriter, r *http.Request) {
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	// is-system-running exits non-zero for any state other than running but
+	// still prints the state, so only a missing state is an error
+	out, _, err := runCommand(r.Context(), "systemctl", scopeFlag, "is-system-running")
+	if err == errCommandTimeout {
+		writeTimeoutError(w, "Timed out fetching manager state")
+		return
+	}
+	state := strings.TrimSpace(out)
+	if state == "" {
+		http.Error(w, "Error fetching manager state", http.StatusInternalServerError)
+		return
+	}
+
+	stdout, err := commandOutput(r.Context(), "systemctl", scopeFlag, "list-units", "--all", "--plain", "--no-legend")
+	if err == errCommandTimeout {
+		writeTimeoutError(w, "Timed out fetching units")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching units", http.StatusInternalServerError)
+		return
+	}
+	units, err := parseUnits(stdout, ".")
+	if err != nil {
+		http.Error(w, "Error parsing units output", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeUnits(state, units))
+}
+
+// Variable names whose values are hidden when redaction is requested
+var secretNameRe = regexp.MustCompile(`(?i)(PASS|SECRET|TOKEN|KEY|CREDENTIAL|AUTH|PRIVATE)`)
+
+const redactedValue = "********"
+
+type EnvironmentFile struct {
+	Path      string            `json:"path"`
+	Optional  bool              `json:"optional"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Splits a value as printed by systemctl show into words, honoring double
+// and single quotes and backslash escapes
+func splitQuoted(value string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range value {
+		switch {
+		case escaped:
+			switch c {
+			case 'n':
+				word.WriteRune('\n')
+			case 't':
+				word.WriteRune('\t')
+			default:
+				word.WriteRune(c)
+			}
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// Parses the Environment property into a map of variables
+func parseEnvironment(value string) map[string]string {
+	variables := map[string]string{}
+	for _, assignment := range splitQuoted(value) {
+		if name, val, found := strings.Cut(assignment, "="); found && name != "" {
+			variables[name] = val
+		}
+	}
+	return variables
+}
+
+// Parses an EnvironmentFiles line such as "/etc/app.env (ignore_errors=yes)"
+func parseEnvironmentFileEntry(value string) EnvironmentFile {
+	file := EnvironmentFile{Path: value}
+	if i := strings.LastIndex(value, " (ignore_errors="); i >= 0 {
+		file.Path = value[:i]
+		file.Optional = strings.HasPrefix(value[i:], " (ignore_errors=yes")
+	}
+	return file
+}
+
+// Reads KEY=VALUE assignments from an environment file, skipping blank lines
+// and comments
+func readEnvironmentFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	variables := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		name = strings.TrimSpace(strings.TrimPrefix(name, "export "))
+		value = strings.TrimSpace(value)
+		if words := splitQuoted(value); len(words) == 1 {
+			value = words[0]
+		}
+		variables[name] = value
+	}
+	return variables, nil
+}
+
+// Replaces the values of variables that look like secrets
+func redactVariables(variables map[string]string) {
+	for name := range variables {
+		if secretNameRe.MatchString(name) {
+			variables[name] = redactedValue
+		}
+	}
+}
+
+func ServiceEnvironment(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+	redact := r.URL.Query().Get("redact") == "true"
+
+	// EnvironmentFiles is printed once per file, so the raw output is parsed
+	// here instead of going through showUnitProperties
+	out, err := commandOutput(r.Context(), "systemctl", scopeFlag, "show", "-p", "LoadState", "-p", "Environment", "-p", "EnvironmentFiles", "--", service)
+	if err != nil {
+		writeUnitReadError(w, "Error fetching environment of "+service, err)
+		return
+	}
+
+	variables := map[string]string{}
+	files := []EnvironmentFile{}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "LoadState":
+			if value == "not-found" {
+				http.Error(w, "Service "+service+" not found", http.StatusNotFound)
+				return
+			}
+		case "Environment":
+			variables = parseEnvironment(value)
+		case "EnvironmentFiles":
+			if value != "" {
+				files = append(files, parseEnvironmentFileEntry(value))
+			}
+		}
+	}
+
+	for i := range files {
+		fileVariables, err := readEnvironmentFile(files[i].Path)
+		if err != nil {
+			files[i].Error = "Error reading environment file"
+			continue
+		}
+		if redact {
+			redactVariables(fileVariables)
+		}
+		files[i].Variables = fileVariables
+	}
+	if redact {
+		redactVariables(variables)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":        service,
+		"environment": variables,
+		"files":       files,
+		"redacted":    redact,
+	})
+}
+
+type VerifyIssue struct {
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+var unitFileSuffixRe = regexp.MustCompile(`\.(service|socket|timer|target|path|mount|automount|swap|slice|scope)$`)
+
+var verifyLineRe = regexp.MustCompile(`^(.*?):(\d+): (.*)$`)
+
+// Parses systemd-analyze verify output about the file at path. Lines about
+// other units pulled in by dependencies are kept, with the file path replaced
+// by the unit name. Problems systemd skips over are warnings, the rest errors.
+func parseVerifyOutput(output, path, name string) []VerifyIssue {
+	issues := []VerifyIssue{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		issue := VerifyIssue{Message: line}
+		if match := verifyLineRe.FindStringSubmatch(line); match != nil && match[1] == path {
+			issue.Line, _ = strconv.Atoi(match[2])
+			issue.Message = match[3]
+		}
+		issue.Message = strings.ReplaceAll(issue.Message, path, name)
+		issue.Severity = "error"
+		if strings.Contains(strings.ToLower(issue.Message), "ignoring") {
+			issue.Severity = "warning"
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+func VerifyUnitFile(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "unit.service"
+	}
+	if !validateUnitName(name) || !unitFileSuffixRe.MatchString(name) || strings.ContainsAny(name, `/\`) {
+		http.Error(w, "Invalid unit file name", http.StatusBadRequest)
+		return
+	}
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		if components.IsBodyTooLarge(err) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		http.Error(w, "Unit file content is required", http.StatusBadRequest)
+		return
+	}
+
+	// systemd-analyze infers the unit type from the file name, so the content
+	// is written under the requested name in a private directory
+	dir, err := os.MkdirTemp("", "napi-verify-")
+	if err != nil {
+		http.Error(w, "Error creating temporary file", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		http.Error(w, "Error creating temporary file", http.StatusInternalServerError)
+		return
+	}
+
+	stdout, stderr, err := runCommand(r.Context(), "systemd-analyze", "--user", "verify", path)
+	if err == errCommandTimeout {
+		writeTimeoutError(w, "Timed out verifying unit file")
+		return
+	}
+	output := strings.TrimSpace(stdout + "\n" + stderr)
+	valid := err == nil
+	if _, exited := exitCode(err); err != nil && !exited {
+		http.Error(w, "Error running systemd-analyze", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":   name,
+		"valid":  valid,
+		"issues": parseVerifyOutput(output, path, name),
+	})
+}
+
+// UnitFragment is one file of a unit definition as printed by systemctl cat
+type UnitFragment struct {
+	Path    string `json:"path"`
+	DropIn  bool   `json:"drop_in"`
+	Content string `json:"content"`
+}
+
+// Splits systemctl cat output into its files. Each file starts with a
+// "# /path" comment line and files are separated by an empty line.
+func parseUnitCat(output string) []UnitFragment {
+	fragments := []UnitFragment{}
+	var lines []string
+	flush := func() {
+		if len(fragments) == 0 {
+			return
+		}
+		for len(lines) > 0 && lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		fragments[len(fragments)-1].Content = strings.Join(lines, "\n")
+		if len(lines) > 0 {
+			fragments[len(fragments)-1].Content += "\n"
+		}
+		lines = nil
+	}
+	previousBlank := true
+	for _, line := range strings.Split(output, "\n") {
+		if previousBlank && strings.HasPrefix(line, "# /") {
+			flush()
+			path := strings.TrimPrefix(line, "# ")
+			fragments = append(fragments, UnitFragment{
+				Path:   path,
+				DropIn: strings.Contains(path, ".d/"),
+			})
+			previousBlank = false
+			continue
+		}
+		if len(fragments) > 0 {
+			lines = append(lines, line)
+		}
+		previousBlank = line == ""
+	}
+	flush()
+	return fragments
+}
+
+func CatServiceUnit(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+
+	stdout, stderr, err := runCommand(r.Context(), "systemctl", scopeFlag, "cat", "--", service)
+	if err != nil {
+		writeSystemctlError(w, "Error reading unit "+service, stderr, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":      service,
+		"fragments": parseUnitCat(stdout),
+	})
+}
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 120 * time.Second
+	watchPollInterval   = time.Second
+)
+
+// Reads the ActiveState and SubState of a unit
+func readActiveState(ctx context.Context, scopeFlag, unit string) (map[string]string, error) {
+	out, err := commandOutput(ctx, "systemctl", scopeFlag, "show", "-p", "LoadState", "-p", "ActiveState", "-p", "SubState", "--", unit)
+	if err != nil {
+		return nil, err
+	}
+	return parseProperties(out), nil
+}
+
+func WatchService(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+	timeout := defaultWatchTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxWatchTimeout {
+			http.Error(w, "timeout must be between 1 and "+strconv.Itoa(int(maxWatchTimeout.Seconds()))+" seconds", http.StatusBadRequest)
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	current, err := readActiveState(r.Context(), scopeFlag, service)
+	if err != nil {
+		http.Error(w, "Error reading state of "+service, http.StatusInternalServerError)
+		return
+	}
+	if current["LoadState"] == "not-found" {
+		http.Error(w, "Service "+service+" not found", http.StatusNotFound)
+		return
+	}
+	// The state the client last saw; without it the state at the time of the
+	// request is the baseline
+	previous := map[string]string{
+		"ActiveState": current["ActiveState"],
+		"SubState":    current["SubState"],
+	}
+	if value := r.URL.Query().Get("active"); value != "" {
+		previous["ActiveState"] = value
+	}
+	if value := r.URL.Query().Get("sub"); value != "" {
+		previous["SubState"] = value
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for current["ActiveState"] == previous["ActiveState"] && current["SubState"] == previous["SubState"] {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-ticker.C:
+		}
+		if current, err = readActiveState(r.Context(), scopeFlag, service); err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			http.Error(w, "Error reading state of "+service, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"unit":        service,
+		"ActiveState": current["ActiveState"],
+		"SubState":    current["SubState"],
+		"previous":    previous,
+	})
+}
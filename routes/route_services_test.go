@@ -0,0 +1,526 @@
+// routes/route_services_test.go
+
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateUnitPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"*", true},
+		{"nginx*.service", true},
+		{"app@?.service", true},
+		{"worker-[0-9].service", true},
+		{`escaped\*.service`, true},
+		{"", false},
+		{"worker-[0-9.service", false},
+		{"a b.service", false},
+		{"../*.service", false},
+		{"$(reboot)", false},
+		{strings.Repeat("a", 257), false},
+	}
+	for _, tt := range tests {
+		if got := validateUnitPattern(tt.pattern); got != tt.want {
+			t.Errorf("validateUnitPattern(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+const failedUnitListing = `cron.service        loaded active running Regular background program processing daemon
+● web-1.service     loaded failed failed  Web worker 1
+● web-2.service     loaded failed failed  Web worker 2
+● backup.timer      loaded failed failed  Nightly backup
+web-3.service       loaded active running Web worker 3
+`
+
+func TestMatchFailedUnits(t *testing.T) {
+	units, err := parseUnits(failedUnitListing, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*", []string{"web-1.service", "web-2.service", "backup.timer"}},
+		{"web-*.service", []string{"web-1.service", "web-2.service"}},
+		{"web-[2-9].service", []string{"web-2.service"}},
+		{"*.timer", []string{"backup.timer"}},
+		{"cron.service", []string{}},
+		{"nothing*", []string{}},
+	}
+	for _, tt := range tests {
+		matched := matchFailedUnits(units, tt.pattern)
+		got := []string{}
+		for _, unit := range matched {
+			got = append(got, unit.UNIT)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("matchFailedUnits(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestResetFailedPatternCap(t *testing.T) {
+	tests := []struct {
+		name          string
+		failed        int
+		wantReset     int
+		wantTruncated bool
+	}{
+		{"below the cap", 3, 3, false},
+		{"at the cap", maxPatternUnits, maxPatternUnits, false},
+		{"above the cap", maxPatternUnits + 10, maxPatternUnits, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var listing strings.Builder
+			results := map[string]fakeResult{}
+			for i := 0; i < tt.failed; i++ {
+				unit := fmt.Sprintf("job-%02d.service", i)
+				fmt.Fprintf(&listing, "● %s loaded failed failed Job %d\n", unit, i)
+				results["systemctl --user reset-failed -- "+unit] = fakeResult{}
+			}
+			results["systemctl --user list-units --all --plain --no-legend"] = fakeResult{stdout: listing.String()}
+			useFakeRunner(t, results)
+
+			w := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", "/system/services/reset-failed-pattern", strings.NewReader(`{"pattern":"job-*.service"}`))
+			request.Header.Set("Content-Type", "application/json")
+			ResetFailedPattern(w, request)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			var body struct {
+				Reset     []string `json:"reset"`
+				Truncated bool     `json:"truncated"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Reset) != tt.wantReset || body.Truncated != tt.wantTruncated {
+				t.Errorf("reset %d units, truncated %v; want %d, %v", len(body.Reset), body.Truncated, tt.wantReset, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestParseProperties(t *testing.T) {
+	got := parseProperties("StandardOutput=journal\nStandardError=inherit\nSyslogIdentifier=\nEnvironment=A=1 B=2\nnot a property\n")
+	want := map[string]string{
+		"StandardOutput":   "journal",
+		"StandardError":    "inherit",
+		"SyslogIdentifier": "",
+		"Environment":      "A=1 B=2",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseProperties() = %q, want %q", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("parseProperties()[%q] = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestValidateOutputTarget(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"journal", true},
+		{"journal+console", true},
+		{"null", true},
+		{"file:/var/log/app.log", true},
+		{"append:/var/log/app.log", true},
+		{"truncate:/tmp/out", true},
+		{"fd:stdout", true},
+		{"", false},
+		{"syslog", false},
+		{"file:relative.log", false},
+		{"file:", false},
+		{"append:/var/log/app.log\nExecStart=/bin/sh", false},
+		{"fd:bad name", false},
+		{"socket:/run/app.sock", false},
+	}
+	for _, tt := range tests {
+		if got := validateOutputTarget(tt.value); got != tt.want {
+			t.Errorf("validateOutputTarget(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetOutputConfig(t *testing.T) {
+	useFakeRunner(t, map[string]fakeResult{
+		"systemctl --user show -p StandardOutput -p StandardError -p SyslogIdentifier -- app.service": {
+			stdout: "StandardOutput=journal\nStandardError=inherit\nSyslogIdentifier=app\n",
+		},
+	})
+
+	w := httptest.NewRecorder()
+	GetOutputConfig(w, httptest.NewRequest("GET", "/system/services/output-config?target=app.service", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Config map[string]string `json:"config"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Config["StandardOutput"] != "journal" || body.Config["StandardError"] != "inherit" || body.Config["SyslogIdentifier"] != "app" {
+		t.Errorf("config = %q", body.Config)
+	}
+}
+
+func TestSetOutputConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantDropIn string
+	}{
+		{"journal and identifier", `{"StandardOutput":"journal","SyslogIdentifier":"app"}`, http.StatusOK, "[Service]\nStandardOutput=journal\nSyslogIdentifier=app\n"},
+		{"file target", `{"StandardError":"append:/var/log/app.err"}`, http.StatusOK, "[Service]\nStandardError=append:/var/log/app.err\n"},
+		{"invalid target", `{"StandardOutput":"file:app.log"}`, http.StatusBadRequest, ""},
+		{"invalid identifier", `{"SyslogIdentifier":"app name"}`, http.StatusBadRequest, ""},
+		{"nothing to set", `{"Restart":"always"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configDir := t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", configDir)
+			runner := useFakeRunner(t, map[string]fakeResult{"systemctl --user daemon-reload": {}})
+
+			w := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", "/system/services/output-config?target=app.service", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			SetOutputConfig(w, request)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			content, err := os.ReadFile(filepath.Join(configDir, "systemd", "user", "app.service.d", "output.conf"))
+			if tt.wantDropIn == "" {
+				if err == nil {
+					t.Errorf("drop-in written for a rejected request: %q", content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.wantDropIn {
+				t.Errorf("drop-in = %q, want %q", content, tt.wantDropIn)
+			}
+			if !runner.ran("systemctl --user daemon-reload") {
+				t.Errorf("calls = %q, want a daemon-reload", runner.calls)
+			}
+		})
+	}
+}
+
+func TestSetOutputConfigMerges(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	useFakeRunner(t, map[string]fakeResult{"systemctl --user daemon-reload": {}})
+
+	updates := []struct {
+		body       string
+		wantDropIn string
+	}{
+		{`{"StandardOutput":"journal"}`, "[Service]\nStandardOutput=journal\n"},
+		{`{"SyslogIdentifier":"app"}`, "[Service]\nStandardOutput=journal\nSyslogIdentifier=app\n"},
+		{`{"StandardOutput":"append:/var/log/app.log"}`, "[Service]\nStandardOutput=append:/var/log/app.log\nSyslogIdentifier=app\n"},
+	}
+	for _, update := range updates {
+		w := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/system/services/output-config?target=app.service", strings.NewReader(update.body))
+		request.Header.Set("Content-Type", "application/json")
+		SetOutputConfig(w, request)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", update.body, w.Code, w.Body)
+		}
+		content, err := os.ReadFile(filepath.Join(configDir, "systemd", "user", "app.service.d", "output.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != update.wantDropIn {
+			t.Errorf("after %s: drop-in = %q, want %q", update.body, content, update.wantDropIn)
+		}
+	}
+}
+
+func TestRestartIfChanged(t *testing.T) {
+	dir := t.TempDir()
+	fragment := filepath.Join(dir, "app.service")
+	dropIn := filepath.Join(dir, "override.conf")
+	if err := os.WriteFile(fragment, []byte("[Service]\nExecStart=/usr/bin/app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dropIn, []byte("[Service]\nRestart=always\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner := useFakeRunner(t, map[string]fakeResult{
+		"systemctl --user show -p FragmentPath -p DropInPaths -- app.service": {stdout: "FragmentPath=" + fragment + "\nDropInPaths=" + dropIn + "\n"},
+		"systemctl --user restart -- app.service":                             {},
+	})
+	delete(unitChecksums, "--user app.service")
+
+	var lastChecksum string
+	steps := []struct {
+		name          string
+		change        string
+		sendChecksum  bool
+		wantRestarted bool
+	}{
+		{"first request records", "", false, false},
+		{"unchanged files", "", false, false},
+		{"drop-in edited", "[Service]\nRestart=on-failure\n", false, true},
+		{"client checksum matches", "", true, false},
+	}
+	for _, step := range steps {
+		if step.change != "" {
+			if err := os.WriteFile(dropIn, []byte(step.change), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		target := "/system/services/restart-if-changed?target=app.service"
+		if step.sendChecksum {
+			target += "&checksum=" + lastChecksum
+		}
+		runner.calls = nil
+
+		w := httptest.NewRecorder()
+		RestartIfChanged(w, httptest.NewRequest("POST", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", step.name, w.Code, w.Body)
+		}
+		var body struct {
+			Checksum  string   `json:"checksum"`
+			Files     []string `json:"files"`
+			Restarted bool     `json:"restarted"`
+			Reason    string   `json:"reason"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if first := lastChecksum == ""; (body.Reason != "") != first {
+			t.Errorf("%s: reason = %q", step.name, body.Reason)
+		}
+		if body.Restarted != step.wantRestarted {
+			t.Errorf("%s: restarted = %v, want %v", step.name, body.Restarted, step.wantRestarted)
+		}
+		if ran := runner.ran("systemctl --user restart -- app.service"); ran != step.wantRestarted {
+			t.Errorf("%s: restart ran = %v, want %v", step.name, ran, step.wantRestarted)
+		}
+		if len(body.Files) != 2 {
+			t.Errorf("%s: files = %q, want the fragment and the drop-in", step.name, body.Files)
+		}
+		lastChecksum = body.Checksum
+	}
+}
+
+func TestRestartIfChangedMissingFiles(t *testing.T) {
+	useFakeRunner(t, map[string]fakeResult{
+		"systemctl --user show -p FragmentPath -p DropInPaths -- gone.service": {stdout: "FragmentPath=\nDropInPaths=\n"},
+	})
+
+	w := httptest.NewRecorder()
+	RestartIfChanged(w, httptest.NewRequest("POST", "/system/services/restart-if-changed?target=gone.service", nil))
+	if w.Code == http.StatusOK {
+		t.Errorf("status = %d for a unit without files", w.Code)
+	}
+}
+
+func TestParseExitInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		show       string
+		wantResult string
+		wantCode   string
+		wantStatus int
+		wantSignal string
+		wantNR     int
+	}{
+		{"exited cleanly", "ExecMainStatus=0\nExecMainCode=1\nResult=success\nNRestarts=0\n", "success", "exited", 0, "", 0},
+		{"exited with an error", "ExecMainStatus=2\nExecMainCode=1\nResult=exit-code\nNRestarts=4\n", "exit-code", "exited", 2, "", 4},
+		{"killed by a signal", "ExecMainStatus=9\nExecMainCode=2\nResult=signal\nNRestarts=3\n", "signal", "killed", 9, "SIGKILL", 3},
+		{"dumped core", "ExecMainStatus=11\nExecMainCode=3\nResult=core-dump\nNRestarts=1\n", "core-dump", "dumped", 11, "SIGSEGV", 1},
+		{"unknown signal number", "ExecMainStatus=64\nExecMainCode=2\nResult=signal\nNRestarts=0\n", "signal", "killed", 64, "", 0},
+		{"never started", "ExecMainStatus=0\nExecMainCode=0\nResult=success\nNRestarts=0\n", "success", "", 0, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := parseExitInfo("app.service", parseProperties(tt.show))
+			if info.Unit != "app.service" || info.Result != tt.wantResult || info.Code != tt.wantCode || info.Status != tt.wantStatus || info.NRestarts != tt.wantNR {
+				t.Errorf("parseExitInfo() = %+v", info)
+			}
+			signal := ""
+			if info.Signal != nil {
+				signal = *info.Signal
+			}
+			if signal != tt.wantSignal {
+				t.Errorf("signal = %q, want %q", signal, tt.wantSignal)
+			}
+		})
+	}
+}
+
+func TestParseSystemdTimestamp(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   time.Time
+		wantOK bool
+	}{
+		{"Mon 2024-07-01 12:00:00 UTC", time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), true},
+		{"@1719835200", time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"n/a", time.Time{}, false},
+		{"@soon", time.Time{}, false},
+		{"2024-07-01T12:00:00Z", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSystemdTimestamp(tt.value)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("parseSystemdTimestamp(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+const transitioningListing = `web.service      loaded active       running      Web server
+worker.service   loaded activating   start        Worker stuck starting
+cleanup.service  loaded deactivating stop-sigterm Cleanup stopping
+fresh.service    loaded activating   start-pre    Worker without timestamp
+old.timer        loaded inactive     dead         Old timer
+`
+
+func TestTransitioningUnits(t *testing.T) {
+	units, err := parseUnits(transitioningListing, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := map[string]map[string]string{
+		"worker.service":  parseProperties("InactiveExitTimestamp=Mon 2024-07-01 12:00:00 UTC\nActiveExitTimestamp=n/a\n"),
+		"cleanup.service": parseProperties("InactiveExitTimestamp=Sun 2024-06-30 08:00:00 UTC\nActiveExitTimestamp=Mon 2024-07-01 13:59:30 UTC\n"),
+		"fresh.service":   parseProperties("InactiveExitTimestamp=\nActiveExitTimestamp=\n"),
+	}
+	now := time.Date(2024, 7, 1, 14, 0, 0, 0, time.UTC)
+
+	got := transitioningUnits(units, properties, now)
+	want := []struct {
+		unit     string
+		since    string
+		duration float64
+	}{
+		{"worker.service", "2024-07-01T12:00:00Z", 7200},
+		{"cleanup.service", "2024-07-01T13:59:30Z", 30},
+		{"fresh.service", "", 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("transitioningUnits() = %+v, want %d units", got, len(want))
+	}
+	for i, w := range want {
+		if got[i].UNIT != w.unit || got[i].Since != w.since || got[i].Duration != w.duration {
+			t.Errorf("unit %d = %s since %q for %vs, want %s since %q for %vs", i, got[i].UNIT, got[i].Since, got[i].Duration, w.unit, w.since, w.duration)
+		}
+	}
+}
+
+func TestUnitStateActions(t *testing.T) {
+	enabled := UnitState{UnitFileState: "enabled", LoadState: "loaded"}
+	disabled := UnitState{UnitFileState: "disabled", LoadState: "loaded"}
+	runtime := UnitState{UnitFileState: "enabled-runtime", LoadState: "loaded"}
+	static := UnitState{UnitFileState: "static", LoadState: "loaded"}
+	masked := UnitState{UnitFileState: "masked", LoadState: "masked"}
+	runtimeMasked := UnitState{UnitFileState: "masked-runtime", LoadState: "masked"}
+
+	tests := []struct {
+		name    string
+		current UnitState
+		desired string
+		want    []string
+	}{
+		{"enable a disabled unit", disabled, "enabled", []string{"enable"}},
+		{"enable an enabled unit", enabled, "enabled", []string{}},
+		{"enable a masked unit", masked, "enabled", []string{"unmask", "enable"}},
+		{"disable an enabled unit", enabled, "disabled", []string{"disable"}},
+		{"disable a runtime-enabled unit", runtime, "disabled", []string{"disable"}},
+		{"disable a disabled unit", disabled, "disabled", []string{}},
+		{"disable a static unit", static, "disabled", []string{}},
+		{"disable a masked unit", masked, "disabled", []string{"unmask"}},
+		{"mask an enabled unit", enabled, "masked", []string{"mask"}},
+		{"mask a masked unit", masked, "masked", []string{}},
+		{"mask a runtime-masked unit", runtimeMasked, "masked", []string{}},
+		{"unmask a masked unit", masked, "unmasked", []string{"unmask"}},
+		{"unmask an enabled unit", enabled, "unmasked", []string{}},
+		{"unknown state", enabled, "frozen", []string{}},
+	}
+	for _, tt := range tests {
+		if got := unitStateActions(tt.current, tt.desired); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: unitStateActions() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetUnitState(t *testing.T) {
+	show := "systemctl --user show -p UnitFileState -p LoadState -- app.service"
+	tests := []struct {
+		name        string
+		desired     string
+		states      []string
+		wantStatus  int
+		wantActions []string
+		wantState   string
+	}{
+		{"already there", "enabled", []string{"UnitFileState=enabled\nLoadState=loaded\n"}, http.StatusOK, []string{}, "enabled"},
+		{"unmask and enable", "enabled", []string{"UnitFileState=masked\nLoadState=masked\n", "UnitFileState=enabled\nLoadState=loaded\n"}, http.StatusOK, []string{"unmask", "enable"}, "enabled"},
+		{"invalid state", "frozen", nil, http.StatusBadRequest, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &sequenceRunner{fakeRunner: fakeRunner{results: map[string]fakeResult{
+				"systemctl --user unmask -- app.service": {},
+				"systemctl --user enable -- app.service": {},
+			}}, line: show, outputs: tt.states}
+			previous := SetCommandRunner(runner)
+			defer SetCommandRunner(previous)
+
+			w := httptest.NewRecorder()
+			SetUnitState(w, httptest.NewRequest("POST", "/system/services/unit-state?target=app.service&state="+tt.desired, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Actions []string  `json:"actions"`
+				State   UnitState `json:"state"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(body.Actions, ",") != strings.Join(tt.wantActions, ",") || body.State.UnitFileState != tt.wantState {
+				t.Errorf("actions %q, state %q; want %q, %q", body.Actions, body.State.UnitFileState, tt.wantActions, tt.wantState)
+			}
+			for _, action := range tt.wantActions {
+				if !runner.ran("systemctl --user " + action + " -- app.service") {
+					t.Errorf("calls = %q, want %s", runner.calls, action)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// routes/route_settings.go
+
+package routes
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"napi/components"
+)
+
+// LoadSettings reads the environment variables used by the routes, recording
+// invalid values as problems on c and keeping the default of each. It has to
+// run before RegisterSystemRoutes; routes left unconfigured use the defaults.
+func LoadSettings(c *components.Config) {
+	commandConcurrency = c.Int("COMMAND_CONCURRENCY", commandConcurrency, 1)
+	commandQueueSize = c.Int("COMMAND_QUEUE_SIZE", commandQueueSize, 0)
+	commandQueueTimeout = c.Duration("COMMAND_QUEUE_TIMEOUT", commandQueueTimeout, false)
+	sessionConcurrency = c.Int("SESSION_CONCURRENCY", sessionConcurrency, 0)
+	unitListTTL = c.Duration("SERVICES_CACHE_TTL", unitListTTL, true)
+	maxScheduleDays = c.Int("MAX_SCHEDULE_DAYS", maxScheduleDays, 0)
+	atOutputRetention = c.Duration("AT_OUTPUT_RETENTION", atOutputRetention, false)
+	atOutputDirSetting = c.String("AT_OUTPUT_DIR", "")
+
+	defaultCommandTimeout = c.Duration("COMMAND_TIMEOUT", defaultCommandTimeout, true)
+	if value := c.String("COMMAND_TIMEOUTS", ""); value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			route, duration, found := strings.Cut(strings.TrimSpace(entry), "=")
+			timeout, err := time.ParseDuration(duration)
+			if !found || !strings.HasPrefix(route, "/") || err != nil || timeout <= 0 {
+				c.Problemf("COMMAND_TIMEOUTS entry %q: expected /route=duration", entry)
+				continue
+			}
+			commandTimeouts[route] = timeout
+		}
+	}
+
+	sandboxRootSetting = c.String("SANDBOX_ROOT", "")
+	if value := c.String("SANDBOX_ROOTS", ""); value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			name, root, found := strings.Cut(strings.TrimSpace(entry), "=")
+			if !found || name == "" {
+				c.Problemf("SANDBOX_ROOTS entry %q: expected user=/absolute/path", entry)
+				continue
+			}
+			// A relative root is still assigned so that the user's requests
+			// fail rather than fall back to the shared root
+			if !filepath.IsAbs(root) {
+				c.Problemf("SANDBOX_ROOTS entry %q: expected user=/absolute/path", entry)
+			}
+			userSandboxRoots[name] = root
+		}
+	}
+	configFile = c.String("CONFIG_FILE", "")
+
+	// These are enabled by exactly "true", so catch values such as "1" or
+	// "TRUE" that look like they turn the feature on
+	for _, setting := range []struct {
+		name  string
+		value *bool
+	}{
+		{"ALLOW_SYSTEM_SCOPE", &allowSystemScope},
+		{"ALLOW_CHOWN", &allowChown},
+		{"ALLOW_POWER", &allowPower},
+	} {
+		value := c.String(setting.name, "false")
+		if value != "true" && value != "false" {
+			c.Problemf("%s=%q: expected true or false", setting.name, value)
+		}
+		*setting.value = value == "true"
+	}
+	if secret := c.Secret("DOWNLOAD_LINK_SECRET"); secret != "" {
+		downloadLinkKey = []byte(secret)
+	}
+}
@@ -0,0 +1,107 @@
+// routes/route_settings_test.go
+
+package routes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"napi/components"
+)
+
+// Restores the settings LoadSettings writes once the test ends
+func restoreSettings(t *testing.T) {
+	concurrency, queueSize, queueTimeout, sessions := commandConcurrency, commandQueueSize, commandQueueTimeout, sessionConcurrency
+	ttl, days, retention, atDir := unitListTTL, maxScheduleDays, atOutputRetention, atOutputDirSetting
+	root, roots, file := sandboxRootSetting, userSandboxRoots, configFile
+	systemScope, chown, power, key := allowSystemScope, allowChown, allowPower, downloadLinkKey
+	defaultTimeout, timeouts := defaultCommandTimeout, map[string]time.Duration{}
+	for route, timeout := range commandTimeouts {
+		timeouts[route] = timeout
+	}
+	userSandboxRoots = map[string]string{}
+	t.Cleanup(func() {
+		commandConcurrency, commandQueueSize, commandQueueTimeout, sessionConcurrency = concurrency, queueSize, queueTimeout, sessions
+		unitListTTL, maxScheduleDays, atOutputRetention, atOutputDirSetting = ttl, days, retention, atDir
+		sandboxRootSetting, userSandboxRoots, configFile = root, roots, file
+		allowSystemScope, allowChown, allowPower, downloadLinkKey = systemScope, chown, power, key
+		defaultCommandTimeout, commandTimeouts = defaultTimeout, timeouts
+	})
+}
+
+func TestLoadSettings(t *testing.T) {
+	restoreSettings(t)
+	for name, value := range map[string]string{
+		"COMMAND_CONCURRENCY": "4",
+		"SESSION_CONCURRENCY": "0",
+		"SERVICES_CACHE_TTL":  "0",
+		"MAX_SCHEDULE_DAYS":   "30",
+		"AT_OUTPUT_RETENTION": "48h",
+		"COMMAND_TIMEOUT":     "0",
+		"COMMAND_TIMEOUTS":    "/services/restart=90s, /custom=5s",
+		"SANDBOX_ROOT":        "/srv/files",
+		"SANDBOX_ROOTS":       "alice=/srv/alice,bob=/srv/bob",
+		"ALLOW_SYSTEM_SCOPE":  "true",
+		"ALLOW_POWER":         "false",
+	} {
+		t.Setenv(name, value)
+	}
+
+	c := &components.Config{}
+	LoadSettings(c)
+	if problems := c.Problems(); len(problems) > 0 {
+		t.Fatalf("problems = %q", problems)
+	}
+	if commandConcurrency != 4 || sessionConcurrency != 0 || unitListTTL != 0 || maxScheduleDays != 30 || atOutputRetention != 48*time.Hour {
+		t.Errorf("limits = %d, %d, %v, %d, %v", commandConcurrency, sessionConcurrency, unitListTTL, maxScheduleDays, atOutputRetention)
+	}
+	if defaultCommandTimeout != 0 || commandTimeouts["/services/restart"] != 90*time.Second || commandTimeouts["/custom"] != 5*time.Second || commandTimeouts["/services"] != 5*time.Second {
+		t.Errorf("timeouts = %v, %v", defaultCommandTimeout, commandTimeouts)
+	}
+	if sandboxRootSetting != "/srv/files" || userSandboxRoots["alice"] != "/srv/alice" || userSandboxRoots["bob"] != "/srv/bob" {
+		t.Errorf("sandbox = %q, %v", sandboxRootSetting, userSandboxRoots)
+	}
+	if !allowSystemScope || allowChown || allowPower {
+		t.Errorf("flags = %v, %v, %v", allowSystemScope, allowChown, allowPower)
+	}
+}
+
+func TestLoadSettingsInvalid(t *testing.T) {
+	restoreSettings(t)
+	for name, value := range map[string]string{
+		"COMMAND_CONCURRENCY": "0",
+		"COMMAND_QUEUE_SIZE":  "-1",
+		"SERVICES_CACHE_TTL":  "soon",
+		"AT_OUTPUT_RETENTION": "0",
+		"COMMAND_TIMEOUTS":    "services=10s,/power=fast",
+		"SANDBOX_ROOTS":       "alice=relative,nobody",
+		"ALLOW_CHOWN":         "1",
+	} {
+		t.Setenv(name, value)
+	}
+
+	c := &components.Config{}
+	LoadSettings(c)
+	problems := strings.Join(c.Problems(), "\n")
+	for _, name := range []string{"COMMAND_CONCURRENCY", "COMMAND_QUEUE_SIZE", "SERVICES_CACHE_TTL", "AT_OUTPUT_RETENTION", `"services=10s"`, `"/power=fast"`, `"alice=relative"`, `"nobody"`, "ALLOW_CHOWN"} {
+		if !strings.Contains(problems, name) {
+			t.Errorf("problems lack %s:\n%s", name, problems)
+		}
+	}
+
+	// Invalid values keep the defaults, except that a relative root is kept
+	// so that the user's requests fail instead of using the shared root
+	if commandConcurrency != 16 || commandQueueSize != 64 || unitListTTL != 2*time.Second || atOutputRetention != 7*24*time.Hour {
+		t.Errorf("defaults not kept: %d, %d, %v, %v", commandConcurrency, commandQueueSize, unitListTTL, atOutputRetention)
+	}
+	if commandTimeouts["/power"] != 30*time.Second {
+		t.Errorf("/power timeout = %v, want the default", commandTimeouts["/power"])
+	}
+	if allowChown {
+		t.Errorf("ALLOW_CHOWN=1 enabled chown")
+	}
+	if _, err := userSandboxRoot("alice"); err == nil {
+		t.Errorf("relative root of alice accepted")
+	}
+}
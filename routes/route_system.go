@@ -3,10 +3,12 @@
 package routes
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"os"
-	"os/exec"
 	"time"
 	// "regexp"
 	"fmt"
@@ -16,6 +18,8 @@ import (
 	"github.com/ulule/limiter/v3"
 	"github.com/ulule/limiter/v3/drivers/middleware/stdlib"
 	"github.com/ulule/limiter/v3/drivers/store/memory"
+
+	"napi/components"
 )
 
 var (
@@ -28,6 +32,14 @@ var (
 	systemLimiterMiddleware = stdlib.NewMiddleware(systemLimiter)
 )
 
+// SetSystemRate replaces the rate limit applied to /system routes. Call it
+// before RegisterSystemRoutes.
+func SetSystemRate(rate limiter.Rate) {
+	systemRate = rate
+	systemLimiter = limiter.New(systemLimiterStore, systemRate)
+	systemLimiterMiddleware = stdlib.NewMiddleware(systemLimiter)
+}
+
 type Unit struct {
 	UNIT        string `json:"UNIT"`
 	LOAD        string `json:"LOAD"`
@@ -37,19 +49,134 @@ type Unit struct {
 }
 
 func executeCommand(command string) (string, error) {
-	out, err := exec.Command("sh", "-c", command).Output()
+	out, _, err := runCommand(context.Background(), "sh", "-c", command)
 	if err != nil {
 		return "", err
 	}
-	return string(out), nil
+	return out, nil
+}
+
+// Runs a program directly, without a shell, so that it is the process killed
+// when ctx expires
+func commandOutput(ctx context.Context, name string, args ...string) (string, error) {
+	out, _, err := runCommand(ctx, name, args...)
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+
+// Runs a systemctl subcommand with the given scope flag (--user or --system),
+// killing systemctl once ctx expires, and returns its trimmed stderr
+// alongside any error
+func runSystemctlScopeContext(ctx context.Context, scopeFlag string, args ...string) (string, error) {
+	_, stderr, err := runCommand(ctx, "systemctl", append([]string{scopeFlag}, args...)...)
+	// Every unit change goes through here, so listings are refetched after
+	// it whether or not it succeeded
+	invalidateUnitList(scopeFlag)
+	return stderr, err
+}
+
+// Writes a JSON error body with the given status and optional detail text
+func writeJSONError(w http.ResponseWriter, status int, message, detail string) {
+	body := map[string]string{"error": message}
+	if detail != "" {
+		body["detail"] = detail
+	}
+	if id := w.Header().Get(components.RequestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
 }
 
+// Announces a successful service action on the "services" event topic
+func notifyServiceAction(r *http.Request, action, service, scopeFlag string) {
+	user, _ := r.Context().Value("user").(string)
+	components.Broadcast(components.Event{
+		Topic: "services",
+		Type:  action,
+		Data: map[string]string{
+			"unit":  service,
+			"scope": strings.TrimPrefix(scopeFlag, "--"),
+			"user":  user,
+		},
+	})
+}
+
+// Decodes a JSON request body into v, answering 413 when the body exceeds the
+// size limit and 400 when it is not valid JSON
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		if components.IsBodyTooLarge(err) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// Maps a failed systemctl invocation to 404 (unknown unit), 409 (masked or
+// not permitted), 504 (timed out) or 500 (anything else) and reports
+// systemctl's stderr
+func writeSystemctlError(w http.ResponseWriter, message, stderr string, err error) {
+	if err == errCommandTimeout {
+		writeTimeoutError(w, message)
+		return
+	}
+	status := http.StatusInternalServerError
+	code, exited := exitCode(err)
+	if !exited {
+		code = -1
+	}
+	lower := strings.ToLower(stderr)
+	switch {
+	case strings.Contains(lower, "not found"), strings.Contains(lower, "no files found"), strings.Contains(lower, "not loaded"), strings.Contains(lower, "no such file"), code == 5:
+		status = http.StatusNotFound
+	case strings.Contains(lower, "masked"), strings.Contains(lower, "access denied"), strings.Contains(lower, "permission denied"), strings.Contains(lower, "authentication required"), code == 4:
+		status = http.StatusConflict
+	}
+	if stderr == "" && err != nil {
+		stderr = err.Error()
+	}
+	writeJSONError(w, status, message, stderr)
+}
+
+// Whether system scope may be requested, ALLOW_SYSTEM_SCOPE=true
+var allowSystemScope bool
+
+// Resolves the scope query parameter to a systemctl flag. System scope is only
+// granted to admins and only when ALLOW_SYSTEM_SCOPE=true; otherwise the
+// returned status is 403.
+func unitScope(r *http.Request) (string, int, string) {
+	switch r.URL.Query().Get("scope") {
+	case "", "user":
+		return "--user", 0, ""
+	case "system":
+		if !allowSystemScope {
+			return "", http.StatusForbidden, "System scope is disabled"
+		}
+		if role, _ := r.Context().Value("role").(string); role != "admin" {
+			return "", http.StatusForbidden, "System scope requires admin access"
+		}
+		return "--system", 0, ""
+	}
+	return "", http.StatusBadRequest, "scope must be user or system"
+}
 
 func parseUnits(data, unitType string) ([]Unit, error) {
 	lines := strings.Split(data, "\n")
 	units := []Unit{}
 	for _, line := range lines {
 		fields := strings.Fields(line)
+		// Failed units are prefixed with a status marker in the UNIT column
+		if len(fields) > 0 && (fields[0] == "●" || fields[0] == "*") {
+			fields = fields[1:]
+		}
 		if len(fields) < 5 {
 			continue
 		}
@@ -68,90 +195,345 @@ func parseUnits(data, unitType string) ([]Unit, error) {
 	return units, nil
 }
 
+// Unit fields a listing can be narrowed to with the fields parameter
+var unitFields = []string{"UNIT", "LOAD", "ACTIVE", "SUB", "DESCRIPTION"}
+
+// Splits a comma-separated fields parameter into known Unit fields, matched
+// without regard to case, and the names that matched none
+func parseUnitFields(value string) ([]string, []string) {
+	fields, unknown := []string{}, []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, field := range unitFields {
+			if strings.EqualFold(name, field) {
+				fields = append(fields, field)
+				known = true
+				break
+			}
+		}
+		if !known {
+			unknown = append(unknown, name)
+		}
+	}
+	return fields, unknown
+}
+
+// Keeps only fields of each unit
+func projectUnits(units []Unit, fields []string) []map[string]string {
+	projected := make([]map[string]string, 0, len(units))
+	for _, unit := range units {
+		values := map[string]string{
+			"UNIT":        unit.UNIT,
+			"LOAD":        unit.LOAD,
+			"ACTIVE":      unit.ACTIVE,
+			"SUB":         unit.SUB,
+			"DESCRIPTION": unit.DESCRIPTION,
+		}
+		entry := make(map[string]string, len(fields))
+		for _, field := range fields {
+			entry[field] = values[field]
+		}
+		projected = append(projected, entry)
+	}
+	return projected
+}
+
+// Parses a comma-separated states parameter into a set, nil when it names none
+func parseUnitStates(value string) map[string]bool {
+	var states map[string]bool
+	for _, state := range strings.Split(value, ",") {
+		state = strings.ToLower(strings.TrimSpace(state))
+		if state == "" {
+			continue
+		}
+		if states == nil {
+			states = map[string]bool{}
+		}
+		states[state] = true
+	}
+	return states
+}
+
+// Keeps the units whose ACTIVE or SUB state is one of states
+func filterUnitsByState(units []Unit, states map[string]bool) []Unit {
+	filtered := []Unit{}
+	for _, unit := range units {
+		if states[unit.ACTIVE] || states[unit.SUB] {
+			filtered = append(filtered, unit)
+		}
+	}
+	return filtered
+}
+
 func ListServices(w http.ResponseWriter, r *http.Request) {
-	serviceStdout, err := executeCommand("systemctl --user list-units --type=service --all")
-	if err != nil {
-		http.Error(w, "Error fetching services", http.StatusInternalServerError)
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
 		return
 	}
-	services, err := parseUnits(serviceStdout, ".service")
-	if err != nil {
-		http.Error(w, "Error parsing services output", http.StatusInternalServerError)
-		return
+
+	cached, fresh, generation := cachedUnitList(scopeFlag)
+	services, sockets := cached.services, cached.sockets
+	if !fresh {
+		serviceStdout, err := commandOutput(r.Context(), "systemctl", scopeFlag, "list-units", "--type=service", "--all")
+		if err == errCommandTimeout {
+			writeTimeoutError(w, "Timed out fetching services")
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error fetching services", http.StatusInternalServerError)
+			return
+		}
+		services, err = parseUnits(serviceStdout, ".service")
+		if err != nil {
+			http.Error(w, "Error parsing services output", http.StatusInternalServerError)
+			return
+		}
+
+		socketStdout, err := commandOutput(r.Context(), "systemctl", scopeFlag, "list-units", "--type=socket", "--all")
+		if err == errCommandTimeout {
+			writeTimeoutError(w, "Timed out fetching sockets")
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error fetching sockets", http.StatusInternalServerError)
+			return
+		}
+		sockets, err = parseUnits(socketStdout, ".socket")
+		if err != nil {
+			http.Error(w, "Error parsing sockets output", http.StatusInternalServerError)
+			return
+		}
+		storeUnitList(scopeFlag, generation, services, sockets)
 	}
 
-	socketStdout, err := executeCommand("systemctl --user list-units --type=socket --all")
-	if err != nil {
-		http.Error(w, "Error fetching sockets", http.StatusInternalServerError)
+	// The cache holds every unit, so the filter runs on each request
+	states := parseUnitStates(r.URL.Query().Get("states"))
+	if states != nil {
+		services = filterUnitsByState(services, states)
+		sockets = filterUnitsByState(sockets, states)
+	}
+
+	response := map[string]interface{}{
+		"services": services,
+		"sockets":  sockets,
+		"cached":   fresh,
+	}
+	if states != nil {
+		response["matched"] = len(services) + len(sockets)
+	}
+	if value := r.URL.Query().Get("fields"); value != "" {
+		fields, unknown := parseUnitFields(value)
+		if len(unknown) > 0 {
+			response["warning"] = "Unknown fields ignored: " + strings.Join(unknown, ", ")
+		}
+		// Nothing known to keep falls back to the full listing
+		if len(fields) > 0 {
+			response["services"] = projectUnits(services, fields)
+			response["sockets"] = projectUnits(sockets, fields)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ActiveStates in which a unit already counts as started or stopped, so that
+// start and stop have nothing to do
+var (
+	startedStates = map[string]bool{"active": true, "activating": true, "reloading": true, "refreshing": true}
+	stoppedStates = map[string]bool{"inactive": true, "failed": true, "deactivating": true}
+)
+
+// Starts or stops a unit unless its ActiveState already matches, answering
+// with the state before and after. Repeated calls are no-ops.
+func transitionService(w http.ResponseWriter, r *http.Request, action, verb, done string, desired map[string]bool) {
+	service := r.URL.Query().Get("target")
+	if service == "" {
+		http.Error(w, "Service name is required", http.StatusBadRequest)
+		return
+	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+	before, err := readActiveState(r.Context(), scopeFlag, service)
+	if err == errCommandTimeout {
+		writeTimeoutError(w, "Timed out reading state of "+service)
 		return
 	}
-	sockets, err := parseUnits(socketStdout, ".socket")
 	if err != nil {
-		http.Error(w, "Error parsing sockets output", http.StatusInternalServerError)
+		http.Error(w, "Error reading state of "+service, http.StatusInternalServerError)
+		return
+	}
+	if before["LoadState"] == "not-found" {
+		writeJSONError(w, http.StatusNotFound, "Error "+verb+" service "+service, "Unit "+service+" not found.")
 		return
 	}
 
+	noop := desired[before["ActiveState"]]
+	after := before
+	if !noop {
+		if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, action, "--", service); err != nil {
+			writeSystemctlError(w, "Error "+verb+" service "+service, stderr, err)
+			return
+		}
+		notifyServiceAction(r, done, service, scopeFlag)
+		if after, err = readActiveState(r.Context(), scopeFlag, service); err != nil {
+			after = map[string]string{}
+		}
+	}
+
+	message := "Service " + service + " " + done + " successfully"
+	if noop {
+		message = "Service " + service + " is already " + before["ActiveState"]
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"services": services,
-		"sockets":  sockets,
+		"message": message,
+		"noop":    noop,
+		"before":  map[string]string{"ActiveState": before["ActiveState"], "SubState": before["SubState"]},
+		"after":   map[string]string{"ActiveState": after["ActiveState"], "SubState": after["SubState"]},
 	})
 }
 
 func StartService(w http.ResponseWriter, r *http.Request) {
+	transitionService(w, r, "start", "starting", "started", startedStates)
+}
+
+func StopService(w http.ResponseWriter, r *http.Request) {
+	transitionService(w, r, "stop", "stopping", "stopped", stoppedStates)
+}
+
+func RestartService(w http.ResponseWriter, r *http.Request) {
 	service := r.URL.Query().Get("target")
 	if service == "" {
 		http.Error(w, "Service name is required", http.StatusBadRequest)
 		return
 	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
 
-	err := exec.Command("systemctl", "--user", "start", service).Run()
-	if err != nil {
-		http.Error(w, "Error starting service "+service, http.StatusInternalServerError)
+	if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, "restart", "--", service); err != nil {
+		writeSystemctlError(w, "Error restarting service "+service, stderr, err)
 		return
 	}
+	notifyServiceAction(r, "restarted", service, scopeFlag)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Service " + service + " started successfully",
+		"message": "Service " + service + " restarted successfully",
 	})
 }
 
-func StopService(w http.ResponseWriter, r *http.Request) {
+func ReloadService(w http.ResponseWriter, r *http.Request) {
 	service := r.URL.Query().Get("target")
 	if service == "" {
 		http.Error(w, "Service name is required", http.StatusBadRequest)
 		return
 	}
+	if !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
+		return
+	}
+	orRestart := r.URL.Query().Get("orRestart") == "true"
 
-	err := exec.Command("systemctl", "--user", "stop", service).Run()
+	// CanReload decides what reload-or-restart will do, so the action taken
+	// can be reported
+	out, err := commandOutput(r.Context(), "systemctl", scopeFlag, "show", service, "-p", "CanReload", "-p", "LoadState")
+	if err == errCommandTimeout {
+		writeTimeoutError(w, "Timed out fetching service "+service)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error stopping service "+service, http.StatusInternalServerError)
+		http.Error(w, "Error fetching service "+service, http.StatusInternalServerError)
+		return
+	}
+	properties := parseProperties(out)
+	if properties["LoadState"] == "not-found" {
+		writeJSONError(w, http.StatusNotFound, "Service "+service+" not found", "")
+		return
+	}
+	canReload := properties["CanReload"] == "yes"
+	if !canReload && !orRestart {
+		writeJSONError(w, http.StatusConflict, "Service "+service+" does not support reload", "pass orRestart=true to restart it instead")
 		return
 	}
 
+	command, action := "reload", "reloaded"
+	if orRestart {
+		command = "reload-or-restart"
+		if !canReload {
+			action = "restarted"
+		}
+	}
+	if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, command, "--", service); err != nil {
+		writeSystemctlError(w, "Error reloading service "+service, stderr, err)
+		return
+	}
+	notifyServiceAction(r, action, service, scopeFlag)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Service " + service + " stopped successfully",
+		"message": "Service " + service + " " + action + " successfully",
+		"action":  action,
 	})
 }
 
-func RestartService(w http.ResponseWriter, r *http.Request) {
+func ResetFailedService(w http.ResponseWriter, r *http.Request) {
+	// Without a target every failed unit is reset
 	service := r.URL.Query().Get("target")
-	if service == "" {
-		http.Error(w, "Service name is required", http.StatusBadRequest)
+	if service != "" && !validateUnitName(service) {
+		http.Error(w, "Invalid service name", http.StatusBadRequest)
+		return
+	}
+	scopeFlag, status, problem := unitScope(r)
+	if status != 0 {
+		http.Error(w, problem, status)
 		return
 	}
 
-	err := exec.Command("systemctl", "--user", "restart", service).Run()
-	if err != nil {
-		http.Error(w, "Error restarting service "+service, http.StatusInternalServerError)
+	args := []string{"reset-failed"}
+	if service != "" {
+		args = append(args, "--", service)
+	}
+	if stderr, err := runSystemctlScopeContext(r.Context(), scopeFlag, args...); err != nil {
+		if service == "" {
+			writeSystemctlError(w, "Error resetting failed units", stderr, err)
+		} else {
+			writeSystemctlError(w, "Error resetting failed state of "+service, stderr, err)
+		}
 		return
 	}
 
+	message := "Failed state of all units reset successfully"
+	if service != "" {
+		message = "Failed state of " + service + " reset successfully"
+		notifyServiceAction(r, "reset-failed", service, scopeFlag)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Service " + service + " restarted successfully",
+		"message": message,
 	})
 }
 
@@ -164,8 +546,57 @@ func WriteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fullPath := filepath + "/" + filename
-	err := os.WriteFile(fullPath, []byte(filecontent), 0644)
+	appendMode := r.URL.Query().Get("append") == "true"
+
+	if format := r.URL.Query().Get("validate"); format != "" {
+		if appendMode {
+			http.Error(w, "validate cannot be combined with append", http.StatusBadRequest)
+			return
+		}
+		if format != "json" && format != "yaml" {
+			http.Error(w, "validate must be json or yaml", http.StatusBadRequest)
+			return
+		}
+		if err := validateContent(format, []byte(filecontent)); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":      "File content is not valid " + format,
+				"validation": err,
+				"request_id": components.RequestID(r),
+			})
+			return
+		}
+	}
+
+	fullPath, err := resolveSandboxPath(r, filepath, filename)
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("createDirs") == "true" {
+		if err := createParentDirs(fullPath); err != nil {
+			http.Error(w, "Error creating directory "+filepath, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if appendMode {
+		size, err := appendToFile(fullPath, []byte(filecontent))
+		if err != nil {
+			http.Error(w, "Error appending to file "+filename+" at "+filepath, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Content appended to file " + filename + " at " + filepath,
+			"size":    size,
+		})
+		return
+	}
+
+	err = writeFileAtomic(fullPath, []byte(filecontent))
 	if err != nil {
 		http.Error(w, "Error saving file "+filename+" at "+filepath, http.StatusInternalServerError)
 		return
@@ -185,7 +616,33 @@ func ReadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fullPath := filepath + "/" + filename
+	fullPath, err := resolveSandboxPath(r, filepath, filename)
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	lineRange := query.Get("startLine") != "" || query.Get("endLine") != ""
+	byteRange := query.Get("offset") != "" || query.Get("length") != ""
+	if lineRange && byteRange {
+		http.Error(w, "Line and byte ranges cannot be combined", http.StatusBadRequest)
+		return
+	}
+	raw := query.Get("raw") == "true" || query.Get("download") == "true"
+	if raw && (lineRange || byteRange) {
+		http.Error(w, "Ranges cannot be combined with raw or download, use a Range header instead", http.StatusBadRequest)
+		return
+	}
+	if lineRange || byteRange {
+		readFileRange(w, r, fullPath, lineRange)
+		return
+	}
+	if raw {
+		serveRawFile(w, r, fullPath, query.Get("download") == "true")
+		return
+	}
+
 	fileContent, err := os.ReadFile(fullPath)
 	if err != nil {
 		http.Error(w, "Error reading file "+filename+" at "+filepath, http.StatusInternalServerError)
@@ -198,6 +655,24 @@ func ReadFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Schedules command as a transient systemd timer and returns the timer unit
+// name. A time starting with "+" is relative to now (--on-active), anything
+// else is a calendar expression (--on-calendar).
+func scheduleWithSystemdRun(ctx context.Context, when, command string) (string, string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	unit := "napi-task-" + hex.EncodeToString(buf)
+
+	trigger := "--on-calendar=" + when
+	if strings.HasPrefix(when, "+") {
+		trigger = "--on-active=" + strings.TrimPrefix(when, "+")
+	}
+	_, stderr, err := runCommand(ctx, "systemd-run", "--user", "--unit="+unit, trigger, "--timer-property=AccuracySec=1s", "--collect", "--", "sh", "-c", command)
+	return unit + ".timer", stderr, err
+}
+
 func ScheduleTask(w http.ResponseWriter, r *http.Request) {
 	time := r.URL.Query().Get("time")
 	command := r.URL.Query().Get("command")
@@ -206,29 +681,160 @@ func ScheduleTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	atCommand := fmt.Sprintf(`echo "%s" | at %s`, command, time)
-	_, err := executeCommand(atCommand)
+	switch r.URL.Query().Get("backend") {
+	case "", "at":
+	case "systemd-run":
+		if len(time) > 128 || strings.ContainsAny(time, "\n\r") {
+			http.Error(w, "Invalid time value", http.StatusBadRequest)
+			return
+		}
+		problem := relativeScheduleProblem(time)
+		if !strings.HasPrefix(time, "+") {
+			var stderr string
+			var err error
+			problem, stderr, err = calendarScheduleProblem(r.Context(), time)
+			if _, exited := exitCode(err); exited {
+				writeJSONError(w, http.StatusBadRequest, "Invalid time value", stderr)
+				return
+			}
+			if err != nil {
+				writeSystemctlError(w, "Error resolving calendar time "+time, stderr, err)
+				return
+			}
+		}
+		if problem != "" {
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+		timer, stderr, err := scheduleWithSystemdRun(r.Context(), time, command)
+		if err != nil {
+			// systemd-run rejects malformed times before creating anything
+			if _, exited := exitCode(err); exited && strings.Contains(strings.ToLower(stderr), "failed to parse") {
+				writeJSONError(w, http.StatusBadRequest, "Invalid time value", stderr)
+				return
+			}
+			writeSystemctlError(w, "Error scheduling task at "+time, stderr, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Task scheduled at " + time,
+			"backend": "systemd-run",
+			"unit":    timer,
+			"service": strings.TrimSuffix(timer, ".timer") + ".service",
+		})
+		return
+	default:
+		http.Error(w, "backend must be at or systemd-run", http.StatusBadRequest)
+		return
+	}
+
+	// Specs parseAtTime does not understand are checked against the time at
+	// reports once the job exists
+	problem, parsed := atScheduleProblem(time)
+	if problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	// The job's output goes to a file under atOutputDir, read back through
+	// /at/output
+	outputDir, err := atOutputDir()
+	if err != nil {
+		http.Error(w, "Error locating job output directory", http.StatusInternalServerError)
+		return
+	}
+	sweepAtOutput(r.Context(), outputDir)
+	script, outputName, err := wrapAtCommand(outputDir, command)
+	if err != nil {
+		http.Error(w, "Error preparing job output", http.StatusInternalServerError)
+		return
+	}
+	atCommand := fmt.Sprintf("printf '%%s\\n' %s | at %s", shellQuote(script), shellQuote(time))
+	_, stderr, err := runCommand(r.Context(), "sh", "-c", atCommand)
 	if err != nil {
 		http.Error(w, "Error scheduling task at "+time, http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	if !parsed {
+		if problem := reportedScheduleProblem(stderr); problem != "" {
+			if match := atJobRe.FindStringSubmatch(stderr); match != nil {
+				runCommand(r.Context(), "atrm", match[1])
+			}
+			http.Error(w, problem, http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
 		"message": "Task scheduled at " + time,
-	})
+	}
+	if job, linked := linkAtOutput(outputDir, stderr, outputName); job != "" {
+		response["job"] = job
+		response["output_available"] = linked
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 func RegisterSystemRoutes(r *mux.Router) {
 	systemRouter := r.PathPrefix("/system").Subrouter()
 
 	systemRouter.Use(systemLimiterMiddleware.Handler)
+	systemRouter.Use(capabilityMiddleware)
+	systemRouter.Use(sessionLimitMiddleware)
+	systemRouter.Use(commandLimitMiddleware)
+	systemRouter.Use(commandTimeoutMiddleware)
+	commandSlots = make(chan struct{}, commandConcurrency)
 
 	systemRouter.HandleFunc("/services", ListServices).Methods("GET")
 	systemRouter.HandleFunc("/services/start", StartService).Methods("POST")
 	systemRouter.HandleFunc("/services/stop", StopService).Methods("POST")
 	systemRouter.HandleFunc("/services/restart", RestartService).Methods("POST")
+	systemRouter.HandleFunc("/services/reload", ReloadService).Methods("POST")
+	systemRouter.HandleFunc("/services/reset-failed", ResetFailedService).Methods("POST")
+	systemRouter.HandleFunc("/services/restart-failed", RestartFailedServices).Methods("POST")
+	systemRouter.HandleFunc("/services/restart-if-changed", RestartIfChanged).Methods("POST")
+	systemRouter.Handle("/services/reset-failed-pattern", components.RequireJSONMiddleware(http.HandlerFunc(ResetFailedPattern))).Methods("POST")
+	systemRouter.HandleFunc("/services/output-config", GetOutputConfig).Methods("GET")
+	systemRouter.Handle("/services/output-config", components.RequireJSONMiddleware(http.HandlerFunc(SetOutputConfig))).Methods("POST")
+	systemRouter.HandleFunc("/services/exit-info", ServiceExitInfo).Methods("GET")
+	systemRouter.HandleFunc("/services/logs", ServiceLogs).Methods("GET")
+	systemRouter.HandleFunc("/services/logs/export", ExportServiceLogs).Methods("GET")
+	systemRouter.HandleFunc("/services/unit-state", GetUnitState).Methods("GET")
+	systemRouter.HandleFunc("/services/unit-state", SetUnitState).Methods("POST")
+	systemRouter.HandleFunc("/services/mask", MaskService).Methods("POST")
+	systemRouter.HandleFunc("/services/unmask", UnmaskService).Methods("POST")
+	systemRouter.HandleFunc("/services/watch", WatchService).Methods("GET")
+	systemRouter.HandleFunc("/services/dependencies", ServiceDependencies).Methods("GET")
+	systemRouter.HandleFunc("/services/environment", ServiceEnvironment).Methods("GET")
+	systemRouter.HandleFunc("/services/cat", CatServiceUnit).Methods("GET")
+	systemRouter.HandleFunc("/services/verify", VerifyUnitFile).Methods("POST")
+	systemRouter.HandleFunc("/services/transitioning", TransitioningServices).Methods("GET")
+	systemRouter.HandleFunc("/services/pressure", ServicePressure).Methods("GET")
+	systemRouter.HandleFunc("/services/resources", ServiceResources).Methods("GET")
+	systemRouter.HandleFunc("/pressure", SystemPressure).Methods("GET")
+	systemRouter.HandleFunc("/summary", SystemSummary).Methods("GET")
+	systemRouter.HandleFunc("/analyze/blame", AnalyzeBlame).Methods("GET")
+	systemRouter.HandleFunc("/analyze/time", AnalyzeTime).Methods("GET")
+	systemRouter.HandleFunc("/logs/errors/stream", StreamErrorLogs).Methods("GET")
 	systemRouter.HandleFunc("/write", WriteFile).Methods("POST")
 	systemRouter.HandleFunc("/read", ReadFile).Methods("GET")
+	systemRouter.HandleFunc("/sign-download", SignDownload).Methods("POST")
+	systemRouter.Handle("/read-batch", components.RequireJSONMiddleware(http.HandlerFunc(ReadFileBatch))).Methods("POST")
+	systemRouter.Handle("/move", components.RequireJSONMiddleware(http.HandlerFunc(MoveFile))).Methods("POST")
+	systemRouter.HandleFunc("/mkdir", MakeDirectory).Methods("POST")
+	systemRouter.HandleFunc("/permissions", GetPermissions).Methods("GET")
+	systemRouter.HandleFunc("/chmod", ChmodFile).Methods("POST")
+	systemRouter.HandleFunc("/chown", ChownFile).Methods("POST")
+	systemRouter.HandleFunc("/du", DiskUsageHandler).Methods("GET")
+	systemRouter.HandleFunc("/tail", TailFile).Methods("GET")
+	systemRouter.HandleFunc("/archive", ArchiveDirectory).Methods("GET")
+	systemRouter.HandleFunc("/extract", ExtractArchive).Methods("POST")
+	systemRouter.HandleFunc("/power", PowerAction).Methods("POST")
+	systemRouter.HandleFunc("/config/get", GetConfig).Methods("GET")
+	systemRouter.Handle("/config/set", components.RequireJSONMiddleware(http.HandlerFunc(SetConfig))).Methods("POST")
 	systemRouter.HandleFunc("/at", ScheduleTask).Methods("POST")
+	systemRouter.HandleFunc("/at/output", AtJobOutput).Methods("GET")
 }
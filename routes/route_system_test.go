@@ -0,0 +1,193 @@
+// routes/route_system_test.go
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const serviceListing = `  UNIT             LOAD   ACTIVE SUB     DESCRIPTION
+  cron.service     loaded active running Regular background program processing daemon
+● broken.service   loaded failed failed  Broken service
+
+LOAD   = Reflects whether the unit definition was properly loaded.
+2 loaded units listed.
+`
+
+const socketListing = `  UNIT               LOAD   ACTIVE SUB       DESCRIPTION
+  dbus.socket        loaded active listening D-Bus User Message Bus Socket
+`
+
+func TestParseUnits(t *testing.T) {
+	units, err := parseUnits(serviceListing, ".service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Unit{
+		{UNIT: "cron.service", LOAD: "loaded", ACTIVE: "active", SUB: "running", DESCRIPTION: "Regular background program processing daemon"},
+		{UNIT: "broken.service", LOAD: "loaded", ACTIVE: "failed", SUB: "failed", DESCRIPTION: "Broken service"},
+	}
+	if len(units) != len(want) {
+		t.Fatalf("parseUnits() = %+v, want %+v", units, want)
+	}
+	for i := range want {
+		if units[i] != want[i] {
+			t.Errorf("unit %d = %+v, want %+v", i, units[i], want[i])
+		}
+	}
+}
+
+func TestListServices(t *testing.T) {
+	listings := map[string]fakeResult{
+		"systemctl --user list-units --type=service --all": {stdout: serviceListing},
+		"systemctl --user list-units --type=socket --all":  {stdout: socketListing},
+	}
+	tests := []struct {
+		name         string
+		query        string
+		results      map[string]fakeResult
+		wantStatus   int
+		wantServices int
+		wantSockets  int
+	}{
+		{"all units", "", listings, http.StatusOK, 2, 1},
+		{"filtered by state", "?states=failed", listings, http.StatusOK, 1, 0},
+		{"systemctl fails", "", map[string]fakeResult{}, http.StatusInternalServerError, 0, 0},
+		{"invalid scope", "?scope=global", listings, http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeRunner(t, tt.results)
+			invalidateUnitList("--user")
+
+			w := httptest.NewRecorder()
+			ListServices(w, httptest.NewRequest("GET", "/system/services"+tt.query, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Services []Unit `json:"services"`
+				Sockets  []Unit `json:"sockets"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Services) != tt.wantServices || len(body.Sockets) != tt.wantSockets {
+				t.Errorf("got %d services and %d sockets, want %d and %d", len(body.Services), len(body.Sockets), tt.wantServices, tt.wantSockets)
+			}
+		})
+	}
+}
+
+func TestRestartService(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		results    map[string]fakeResult
+		wantStatus int
+		wantCall   string
+	}{
+		{"restarted", "cron.service", map[string]fakeResult{"systemctl --user restart -- cron.service": {}}, http.StatusOK, "systemctl --user restart -- cron.service"},
+		{"missing target", "", nil, http.StatusBadRequest, ""},
+		{"path as name", "../cron.service", nil, http.StatusBadRequest, ""},
+		// Names may start with a dash, as the root slice does, so they are
+		// passed after -- rather than rejected
+		{"leading dash", "-.slice", map[string]fakeResult{"systemctl --user restart -- -.slice": {}}, http.StatusOK, "systemctl --user restart -- -.slice"},
+		{"unknown unit", "missing.service", map[string]fakeResult{
+			"systemctl --user restart -- missing.service": {stderr: "Unit missing.service not found.", err: fakeExitError(5)},
+		}, http.StatusNotFound, "systemctl --user restart -- missing.service"},
+		{"masked unit", "masked.service", map[string]fakeResult{
+			"systemctl --user restart -- masked.service": {stderr: "Unit masked.service is masked.", err: fakeExitError(1)},
+		}, http.StatusConflict, "systemctl --user restart -- masked.service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := useFakeRunner(t, tt.results)
+
+			w := httptest.NewRecorder()
+			RestartService(w, httptest.NewRequest("POST", "/system/services/restart?target="+tt.target, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCall != "" && !runner.ran(tt.wantCall) {
+				t.Errorf("calls = %q, want %q", runner.calls, tt.wantCall)
+			}
+			if tt.wantCall == "" && len(runner.calls) > 0 {
+				t.Errorf("calls = %q, want none", runner.calls)
+			}
+		})
+	}
+}
+
+// Makes a temporary directory the shared sandbox root for the rest of the test
+// and returns it with symlinks resolved, as the handlers see it
+func useSandbox(t *testing.T) string {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := sandboxRootSetting
+	sandboxRootSetting = root
+	t.Cleanup(func() { sandboxRootSetting = previous })
+	return root
+}
+
+func TestWriteFileValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		content     string
+		wantStatus  int
+		wantWritten bool
+	}{
+		{"valid json", "json", `{"port": 8080}`, http.StatusOK, true},
+		{"invalid json", "json", `{"port": 8080,}`, http.StatusBadRequest, false},
+		{"valid yaml", "yaml", "port: 8080\n", http.StatusOK, true},
+		{"invalid yaml", "yaml", "port: 8080\n  host: x\n", http.StatusBadRequest, false},
+		{"unknown format", "toml", "port = 8080", http.StatusBadRequest, false},
+		{"not validated", "", `{"port": 8080,}`, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useSandbox(t)
+			query := url.Values{"filepath": {"."}, "filename": {"config"}, "filecontent": {tt.content}}
+			if tt.format != "" {
+				query.Set("validate", tt.format)
+			}
+
+			w := httptest.NewRecorder()
+			WriteFile(w, httptest.NewRequest("POST", "/system/write?"+query.Encode(), nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			content, err := os.ReadFile(filepath.Join(root, "config"))
+			if written := err == nil; written != tt.wantWritten {
+				t.Fatalf("file written = %v, want %v", written, tt.wantWritten)
+			}
+			if tt.wantWritten && string(content) != tt.content {
+				t.Errorf("content = %q, want %q", content, tt.content)
+			}
+			if tt.wantStatus == http.StatusBadRequest && tt.format != "toml" {
+				var body struct {
+					Validation ValidationError `json:"validation"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Validation.Format != tt.format || body.Validation.Line == 0 {
+					t.Errorf("validation = %+v, want the %s error and its line", body.Validation, tt.format)
+				}
+			}
+		})
+	}
+}
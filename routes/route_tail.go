@@ -0,0 +1,151 @@
+// routes/route_tail.go
+
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"napi/components"
+)
+
+const (
+	// Size of the blocks read backwards from the end of a file
+	tailChunkBytes = 64 << 10
+	// Bytes read from the end of a file looking for its last lines, so a file
+	// without line breaks is not read whole
+	maxTailBytes = 8 << 20
+)
+
+// Returns the last n lines of file among its first size bytes, and whether
+// maxTailBytes was reached before n lines were found
+func tailLines(file *os.File, size int64, n int) ([]string, bool, error) {
+	var data []byte
+	offset := size
+	for offset > 0 && int64(len(data)) < maxTailBytes {
+		// One line break more than lines are wanted marks where the first of
+		// them starts
+		if bytes.Count(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) >= n {
+			break
+		}
+		chunk := int64(tailChunkBytes)
+		if chunk > offset {
+			chunk = offset
+		}
+		offset -= chunk
+		block := make([]byte, chunk)
+		if _, err := file.ReadAt(block, offset); err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		data = append(block, data...)
+	}
+
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return []string{}, false, nil
+	}
+	lines := strings.Split(text, "\n")
+	truncated := false
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	} else if offset > 0 {
+		// The first line is cut off by the byte limit
+		lines = lines[1:]
+		truncated = true
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines, truncated, nil
+}
+
+func TailFile(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	filepath := r.URL.Query().Get("filepath")
+	if filename == "" || filepath == "" {
+		http.Error(w, "Filename and filepath are required", http.StatusBadRequest)
+		return
+	}
+	lines := defaultLogLines
+	if value := r.URL.Query().Get("lines"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLogLines {
+			http.Error(w, "lines must be between 1 and "+strconv.Itoa(maxLogLines), http.StatusBadRequest)
+			return
+		}
+		lines = parsed
+	}
+
+	fullPath, err := resolveSandboxPath(r, filepath, filename)
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File "+filename+" at "+filepath+" does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading file "+filename+" at "+filepath, http.StatusInternalServerError)
+		return
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		http.Error(w, "File "+filename+" at "+filepath+" is not a regular file", http.StatusBadRequest)
+		return
+	}
+	last, truncated, err := tailLines(file, info.Size(), lines)
+	if err != nil {
+		file.Close()
+		http.Error(w, "Error reading file "+filename+" at "+filepath, http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("follow") != "true" {
+		file.Close()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"filename":  filename,
+			"filepath":  filepath,
+			"lines":     last,
+			"truncated": truncated,
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		file.Close()
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// Following picks up right after the lines already sent
+	if _, err := file.Seek(info.Size(), io.SeekStart); err != nil {
+		file.Close()
+		http.Error(w, "Error reading file "+filename+" at "+filepath, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, line := range last {
+		io.WriteString(w, line+"\n")
+	}
+	flusher.Flush()
+
+	components.FollowFile(r.Context(), fullPath, file, func(line string) error {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+// routes/route_timeouts.go
+
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var errCommandTimeout = errors.New("command timed out")
+
+// Time allowed for a /system request that has no entry in commandTimeouts,
+// set by COMMAND_TIMEOUT; 0 leaves such requests unbounded
+var defaultCommandTimeout = 30 * time.Second
+
+// Time allowed for the commands run by a /system route, keyed by the route
+// path below /system, in place of defaultCommandTimeout. Streams and
+// follow=true requests are never bounded. COMMAND_TIMEOUTS overrides or
+// extends the entries, e.g. COMMAND_TIMEOUTS=/services/restart=60s,/services=3s,
+// read by LoadSettings
+var commandTimeouts = map[string]time.Duration{
+	"/services":              5 * time.Second,
+	"/summary":               5 * time.Second,
+	"/analyze/blame":         10 * time.Second,
+	"/analyze/time":          10 * time.Second,
+	"/services/start":        30 * time.Second,
+	"/services/stop":         30 * time.Second,
+	"/services/restart":      30 * time.Second,
+	"/services/reload":       30 * time.Second,
+	"/services/reset-failed": 30 * time.Second,
+	"/services/mask":         30 * time.Second,
+	"/services/unmask":       30 * time.Second,
+	"/archive":               archiveTimeout,
+	"/extract":               archiveTimeout,
+	"/power":                 30 * time.Second,
+}
+
+// Returns the path template of the matched route below /system, such as
+// "/services/restart"
+func systemRoutePath(r *http.Request) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "", false
+	}
+	i := strings.Index(template, "/system/")
+	if i < 0 {
+		return "", false
+	}
+	return template[i+len("/system"):], true
+}
+
+// Puts the route's command timeout on the request context
+func commandTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routePath, ok := systemRoutePath(r)
+		if !ok || isStreamingRequest(routePath, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout, found := commandTimeouts[routePath]
+		if !found {
+			timeout = defaultCommandTimeout
+		}
+		if timeout == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Converts the error of a command killed by ctx's deadline to errCommandTimeout
+func commandError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errCommandTimeout
+	}
+	return err
+}
+
+// Answers 504 for a command that exceeded its route's timeout
+func writeTimeoutError(w http.ResponseWriter, message string) {
+	writeJSONError(w, http.StatusGatewayTimeout, message, errCommandTimeout.Error())
+}
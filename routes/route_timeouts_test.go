@@ -0,0 +1,58 @@
+// routes/route_timeouts_test.go
+
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCommandTimeoutMiddleware(t *testing.T) {
+	restoreSettings(t)
+	defaultCommandTimeout = time.Minute
+	commandTimeouts["/services/restart"] = 90 * time.Second
+
+	// Records the time left on the request context, 0 without a deadline
+	var left time.Duration
+	record := func(w http.ResponseWriter, r *http.Request) {
+		left = 0
+		if deadline, ok := r.Context().Deadline(); ok {
+			left = time.Until(deadline)
+		}
+	}
+	router := mux.NewRouter()
+	systemRouter := router.PathPrefix("/system").Subrouter()
+	systemRouter.Use(commandTimeoutMiddleware)
+	for _, path := range []string{"/services/restart", "/services/exit-info", "/read", "/services/logs", "/logs/errors/stream"} {
+		systemRouter.HandleFunc(path, record)
+	}
+
+	tests := []struct {
+		target string
+		want   time.Duration
+	}{
+		{"/system/services/restart", 90 * time.Second},
+		{"/system/services/exit-info", time.Minute},
+		{"/system/read", time.Minute},
+		{"/system/services/logs", time.Minute},
+		{"/system/services/logs?follow=true", 0},
+		{"/system/logs/errors/stream", 0},
+	}
+	for _, tt := range tests {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.target, nil))
+		if left > tt.want || left < tt.want-5*time.Second {
+			t.Errorf("%s: %v left, want %v", tt.target, left, tt.want)
+		}
+	}
+
+	// COMMAND_TIMEOUT=0 leaves routes without an entry unbounded
+	defaultCommandTimeout = 0
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/system/read", nil))
+	if left != 0 {
+		t.Errorf("/system/read: %v left without a default timeout", left)
+	}
+}
@@ -0,0 +1,57 @@
+// routes/route_unit_cache.go
+
+package routes
+
+import (
+	"sync"
+	"time"
+)
+
+// How long a ListServices result is served from memory, set through
+// SERVICES_CACHE_TTL and read by LoadSettings; 0 turns the cache off
+var unitListTTL = 2 * time.Second
+
+type unitListEntry struct {
+	services []Unit
+	sockets  []Unit
+	fetched  time.Time
+}
+
+// Cached unit lists by scope flag. generation is bumped by every invalidation
+// so that a listing started before a unit changed is not stored afterwards.
+var (
+	unitListCache      = map[string]unitListEntry{}
+	unitListGeneration = map[string]int{}
+	unitListMu         sync.Mutex
+)
+
+// Returns the fresh cached lists for scopeFlag, if any, and the generation a
+// new listing has to be stored under
+func cachedUnitList(scopeFlag string) (unitListEntry, bool, int) {
+	unitListMu.Lock()
+	defer unitListMu.Unlock()
+	entry, ok := unitListCache[scopeFlag]
+	fresh := ok && time.Since(entry.fetched) < unitListTTL
+	return entry, fresh, unitListGeneration[scopeFlag]
+}
+
+// Stores a listing unless the scope was invalidated since generation was read
+func storeUnitList(scopeFlag string, generation int, services, sockets []Unit) {
+	if unitListTTL == 0 {
+		return
+	}
+	unitListMu.Lock()
+	defer unitListMu.Unlock()
+	if unitListGeneration[scopeFlag] != generation {
+		return
+	}
+	unitListCache[scopeFlag] = unitListEntry{services: services, sockets: sockets, fetched: time.Now()}
+}
+
+// Drops the cached lists for scopeFlag after a unit changed
+func invalidateUnitList(scopeFlag string) {
+	unitListMu.Lock()
+	defer unitListMu.Unlock()
+	delete(unitListCache, scopeFlag)
+	unitListGeneration[scopeFlag]++
+}
@@ -0,0 +1,133 @@
+// routes/route_usage.go
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultUsageDepth = 32
+	maxUsageDepth     = 64
+	// Time a single disk usage walk may take before it stops with a partial result
+	usageWalkTimeout = 10 * time.Second
+)
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// DiskUsage is the result of walking a path. Truncated is set when the depth
+// or time limit stopped the walk early, Reason saying which.
+type DiskUsage struct {
+	Path        string `json:"path"`
+	Bytes       int64  `json:"bytes"`
+	DiskBytes   int64  `json:"disk_bytes"`
+	Files       int64  `json:"files"`
+	Directories int64  `json:"directories"`
+	Unreadable  int64  `json:"unreadable"`
+	Truncated   bool   `json:"truncated"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// Walks root without following symlinks, counting each hard-linked file and
+// each directory once so bind mounts cannot make the walk loop
+func diskUsage(ctx context.Context, root string, maxDepth int) DiskUsage {
+	usage := DiskUsage{Path: root}
+	seen := map[fileID]bool{}
+	rootDepth := strings.Count(filepath.Clean(root), string(filepath.Separator))
+
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			usage.Truncated, usage.Reason = true, "time limit reached"
+			return filepath.SkipAll
+		}
+		if err != nil {
+			usage.Unreadable++
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			usage.Unreadable++
+			return nil
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			id := fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+			if seen[id] {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			seen[id] = true
+			usage.DiskBytes += int64(stat.Blocks) * 512
+		}
+
+		if entry.IsDir() {
+			usage.Directories++
+			if strings.Count(path, string(filepath.Separator))-rootDepth >= maxDepth && path != root {
+				usage.Truncated, usage.Reason = true, "depth limit reached"
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		usage.Files++
+		if info.Mode().IsRegular() {
+			usage.Bytes += info.Size()
+		}
+		return nil
+	})
+	return usage
+}
+
+func DiskUsageHandler(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("filepath")
+	if dir == "" {
+		http.Error(w, "Filepath is required", http.StatusBadRequest)
+		return
+	}
+	maxDepth := defaultUsageDepth
+	if value := r.URL.Query().Get("maxDepth"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > maxUsageDepth {
+			http.Error(w, "maxDepth must be between 0 and "+strconv.Itoa(maxUsageDepth), http.StatusBadRequest)
+			return
+		}
+		maxDepth = parsed
+	}
+
+	fullPath, err := resolveSandboxPath(r, dir, "")
+	if err != nil {
+		writeSandboxError(w, err)
+		return
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Path "+dir+" does not exist", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error reading path "+dir, http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), usageWalkTimeout)
+	defer cancel()
+	usage := diskUsage(ctx, fullPath, maxDepth)
+	usage.Path = dir
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,87 @@
+// routes/route_validate.go
+
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Describes why content failed to parse, with a 1-indexed position when known
+type ValidationError struct {
+	Format  string `json:"format"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("invalid %s at line %d: %s", e.Format, e.Line, e.Message)
+	}
+	return fmt.Sprintf("invalid %s: %s", e.Format, e.Message)
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+)`)
+
+// Converts a byte offset into a 1-indexed line and column
+func offsetPosition(content []byte, offset int64) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// Parses content in the given format ("json" or "yaml") and reports the
+// first syntax error
+func validateContent(format string, content []byte) error {
+	switch format {
+	case "json":
+		var value interface{}
+		err := json.Unmarshal(content, &value)
+		if err == nil {
+			return nil
+		}
+		result := &ValidationError{Format: "json", Message: err.Error()}
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) {
+			// Offset counts the bytes read, the offending one included
+			result.Line, result.Column = offsetPosition(content, syntaxErr.Offset-1)
+		} else if errors.As(err, &typeErr) {
+			result.Line, result.Column = offsetPosition(content, typeErr.Offset)
+		}
+		return result
+	case "yaml":
+		var value interface{}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			err := decoder.Decode(&value)
+			if err == nil {
+				continue
+			}
+			if err == io.EOF {
+				return nil
+			}
+			result := &ValidationError{Format: "yaml", Message: err.Error()}
+			if match := yamlLineRe.FindStringSubmatch(err.Error()); match != nil {
+				result.Line, _ = strconv.Atoi(match[1])
+			}
+			return result
+		}
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
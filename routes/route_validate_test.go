@@ -0,0 +1,61 @@
+// routes/route_validate_test.go
+
+package routes
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		content    string
+		wantValid  bool
+		wantLine   int
+		wantColumn int
+	}{
+		{"valid json object", "json", `{"name": "app", "ports": [80, 443]}`, true, 0, 0},
+		{"valid json scalar", "json", `"text"`, true, 0, 0},
+		{"json trailing comma", "json", `{"a": 1,}`, false, 1, 9},
+		{"json missing value", "json", "{\n  \"a\": 1,\n  \"b\": \n}", false, 4, 1},
+		{"json trailing data", "json", `{"a": 1} x`, false, 1, 10},
+		{"empty json", "json", "", false, 1, 1},
+		{"valid yaml", "yaml", "name: app\nports:\n  - 80\n  - 443\n", true, 0, 0},
+		{"valid yaml documents", "yaml", "a: 1\n---\nb: 2\n", true, 0, 0},
+		{"empty yaml", "yaml", "", true, 0, 0},
+		{"yaml bad indentation", "yaml", "a: 1\n  b: 2\n", false, 2, 0},
+		{"yaml error in second document", "yaml", "a: 1\n---\nb: :\n", false, 3, 0},
+		{"yaml unterminated string", "yaml", "key: \"unterminated\n", false, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateContent(tt.format, []byte(tt.content))
+			if tt.wantValid {
+				if err != nil {
+					t.Errorf("validateContent() = %v, want valid", err)
+				}
+				return
+			}
+			var validation *ValidationError
+			if !errors.As(err, &validation) {
+				t.Fatalf("validateContent() = %v, want a *ValidationError", err)
+			}
+			if validation.Format != tt.format || validation.Message == "" {
+				t.Errorf("validateContent() = %+v", validation)
+			}
+			if validation.Line != tt.wantLine || validation.Column != tt.wantColumn {
+				t.Errorf("position = %d:%d, want %d:%d", validation.Line, validation.Column, tt.wantLine, tt.wantColumn)
+			}
+		})
+	}
+}
+
+func TestValidateContentUnsupportedFormat(t *testing.T) {
+	err := validateContent("toml", []byte("a = 1"))
+	var validation *ValidationError
+	if err == nil || errors.As(err, &validation) {
+		t.Errorf("validateContent(toml) = %v, want an unsupported format error", err)
+	}
+}